@@ -0,0 +1,250 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	search := app.readString(qs, "search", "")
+	filters := model.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "pageSize", 20, v),
+	}
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.List(search, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": details}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.List()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddForUser(details.ID, mux.Vars(r)["role"], app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserDetails(w, r, details.ID)
+}
+
+func (app *application) revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+	if details.ID == app.contextGetUser(r).ID && role == "admin" {
+		v := validator.New()
+		v.AddError("role", "you cannot revoke your own admin role")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Roles.RemoveFromUser(details.ID, role, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserDetails(w, r, details.ID)
+}
+
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.SetActivated(details.ID, *input.Activated, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserDetails(w, r, details.ID)
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	if details.ID == app.contextGetUser(r).ID {
+		v := validator.New()
+		v.AddError("user", "you cannot delete your own account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Users.Delete(details.Username, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filters := model.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "pageSize", 20, v),
+	}
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lockouts, metadata, err := app.models.Users.ListLockouts(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lockouts": lockouts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) clearLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	details, ok := app.readUserDetails(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Users.ResetLoginFailures(details.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserDetails(w, r, details.ID)
+}
+
+// readUserDetails loads the user named by the id route parameter. If it
+// returns false a response has already been written.
+func (app *application) readUserDetails(w http.ResponseWriter, r *http.Request) (*model.UserDetails, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	details, err := app.models.Users.GetDetails(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return details, true
+}
+
+func (app *application) writeUserDetails(w http.ResponseWriter, r *http.Request, id int64) {
+	details, err := app.models.Users.GetDetails(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": details}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
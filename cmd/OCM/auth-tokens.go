@@ -1,9 +1,11 @@
 package main
 
 import (
+	"OCM/pkg/OCM/auth"
 	"OCM/pkg/OCM/model"
 	"OCM/pkg/OCM/validator"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -37,6 +39,12 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	ip := clientIP(r)
+	if wait := app.loginFailures.wait(ip); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
 	var user *model.User
 	if emailValid {
 		user, err = app.models.Users.GetByEmail(input.Email)
@@ -46,6 +54,7 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
+			app.loginFailures.fail(ip)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -53,9 +62,7 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	role, err := app.models.Users.GetRole(user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !app.checkLoginState(w, r, user) {
 		return
 	}
 
@@ -66,45 +73,339 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.loginFailures.fail(ip)
+		app.recordLoginFailure(w, r, user)
+		return
+	}
+
+	// Hashes made with an older scheme or weaker parameters are upgraded
+	// while the plaintext is at hand. Failing to do so must not fail the
+	// login; it is tried again next time.
+	err = app.models.Users.RehashPassword(user, input.Password)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin is called once the user has proven their first factor. It
+// responds with a 2FA challenge if the user has enabled 2FA, and with a
+// token pair otherwise.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *model.User) {
+	twoFactor, err := app.models.Users.GetTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor.Enabled {
+		// Failed logins are only reset once the second factor has been
+		// given too, so the lockout also covers guessing TOTP codes.
+		challengeToken, err := app.auth.GenerateChallengeToken(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"two_factor_required": true, "challenge_token": challengeToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	accessToken, err := app.auth.GenerateAccessToken(user, role)
+
+	err = app.models.Users.ResetLoginFailures(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	refreshToken, err := app.auth.GenerateRefreshToken(user)
+
+	env, err := app.startSession(r, user, false)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	verification := &model.Verification{
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startSession records a new session for the device the request came from
+// and returns its first token pair.
+func (app *application) startSession(r *http.Request, user *model.User, mfa bool) (envelope, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	session := &model.Session{
 		UserID:    user.ID,
-		PlainText: accessToken,
-		Expiry:    time.Now().Add(time.Hour * 24),
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+		MFA:       mfa,
+		Expiry:    time.Now().Add(app.config.jwt.refreshTTL),
+	}
+	// The user is only in the request context once they have a token, so
+	// name them as the actor of their own login.
+	actor := app.actor(r)
+	actor.UserID = user.ID
+	err := app.models.Sessions.Insert(session, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Roles.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return app.issueTokens(user, roles, session)
+}
+
+// issueTokens signs the next access and refresh token of the session and
+// saves their IDs. It returns ErrEditConflict if the session was refreshed
+// concurrently.
+func (app *application) issueTokens(user *model.User, roles model.Roles, session *model.Session) (envelope, error) {
+	previousRefreshID := session.RefreshTokenID
+
+	accessToken, err := app.auth.GenerateAccessToken(user, roles, session)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := app.auth.GenerateRefreshToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Sessions.Update(session, previousRefreshID)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"access_token": accessToken, "refresh_token": refreshToken}, nil
+}
+
+// checkLoginState refuses logins to locked accounts and enforces the
+// backoff between failed attempts. If it returns false a response has
+// already been written.
+func (app *application) checkLoginState(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	state, err := app.models.Users.GetLoginState(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if state.Locked() {
+		app.accountLockedResponse(w, r, time.Until(state.LockedUntil))
+		return false
+	}
+	if state.FailedLogins > 0 && time.Since(state.LastFailedLogin) < app.config.login.lockout {
+		wait := time.Until(state.LastFailedLogin.Add(backoff(state.FailedLogins, app.config.login.backoffBase, app.config.login.backoffMax)))
+		if wait > 0 {
+			app.rateLimitExceededResponse(w, r, wait)
+			return false
+		}
+	}
+	return true
+}
+
+// recordLoginFailure counts a wrong password against the account and locks
+// it once there were too many, emailing the owner a code to unlock it early.
+func (app *application) recordLoginFailure(w http.ResponseWriter, r *http.Request, user *model.User) {
+	state, err := app.models.Users.RecordLoginFailure(user.ID, app.config.login.maxFailures, app.config.login.lockout, app.actor(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !state.JustLocked {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.logger.Printf("account %s locked after %d failed logins", user.Username, state.FailedLogins)
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopeUnlock)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	verification, err := app.models.Verifications.New(user.ID, app.config.login.lockout, model.ScopeUnlock)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"unlockCode":  verification.PlainText,
+			"lockedUntil": state.LockedUntil.Format(time.RFC1123),
+		}
+		err := app.mailer.Send(user.Email, "user_unlock.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+
+	app.accountLockedResponse(w, r, time.Until(state.LockedUntil))
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateVerificationCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByVerificationCode(model.ScopeUnlock, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired unlock code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	verification, err = app.models.Verifications.New(verification.UserID, time.Hour*24)
+	err = app.models.Users.ResetLoginFailures(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"access_token": accessToken, "refresh_token": refreshToken}, nil)
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopeUnlock)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	user.Activated = true
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	session := app.contextGetSession(r)
+
+	roles, err := app.models.Roles.GetUserRoles(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	err = app.models.Users.Update(user)
+	// The refresh token presented here stops being the session's latest, so
+	// it can never be used again.
+	env, err := app.issueTokens(user, roles, session)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := app.contextGetAccessToken(r)
+	if claims == nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err := app.models.Sessions.Delete(claims.UserId, claims.SessionID)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.auth.RevokeAccessToken(claims)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logoutAllHandler ends every session of the user and revokes their access
+// tokens and API keys. It also rotates the token hash, which invalidates refresh tokens
+// and pending 2FA challenges that aren't tied to a stored session.
+func (app *application) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	claims := app.contextGetAccessToken(r)
+	if claims == nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByUsername(claims.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+
+	err = app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.RotateTokenHash(user, app.auth.GenerateRandomString(15))
+	if err != nil && !errors.Is(err, model.ErrEditConflict) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.auth.RevokeAccessToken(claims)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions and your API keys have been revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// jwksHandler publishes the public keys tokens are signed with, so other
+// services can verify them without sharing a secret.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(auth.JWKSMaxAge.Seconds())))
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.auth.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+)
+
+func (app *application) createBanHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64  `json:"user_id"`
+		Days   int    `json:"days"`
+		Reason string `json:"reason"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	v := validator.New()
+	model.ValidateBan(v, input.Days, input.Reason)
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.UserID != admin.ID, "user_id", "you cannot ban yourself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetDetails(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("user_id", "no matching user found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ban, err := app.models.Bans.Insert(input.UserID, input.Days, input.Reason, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateBan):
+			v.AddError("user_id", "this user is already banned")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"ban": ban}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBansHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filters := model.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "pageSize", 20, v),
+	}
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bans, metadata, err := app.models.Bans.ListActive(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bans": bans, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) liftBanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Bans.Lift(id, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "ban successfully lifted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBanStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	ban, err := app.models.Bans.GetActive(user.ID)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"banned": ban != nil}
+	if ban != nil {
+		env["ban"] = envelope{"reason": ban.Reason, "created_at": ban.CreatedAt, "expiry": ban.Expiry}
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.List()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string `json:"name"`
+		ParentID *int64 `json:"parent_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &model.Category{Name: input.Name, ParentID: input.ParentID}
+
+	v := validator.New()
+	if model.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+		// ParentID is raw so that null, which moves the category to the
+		// top level, can be told apart from leaving it out.
+		ParentID json.RawMessage `json:"parent_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		category.Name = *input.Name
+	}
+	if input.ParentID != nil {
+		category.ParentID = nil
+		err = json.Unmarshal(input.ParentID, &category.ParentID)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	if model.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Categories.Delete(id)
+	if err != nil {
+		app.categoryErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCourseCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writeCourseCategories(w, r, int(id))
+}
+
+// setCourseCategoriesHandler replaces the categories of the course.
+func (app *application) setCourseCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CategoryIDs []int64 `json:"category_ids"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Categories.SetForCourse(int(id), input.CategoryIDs, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrCategoryNotFound):
+			v := validator.New()
+			v.AddError("category_ids", "must only contain existing categories")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.courseOwnershipErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCourseCategories(w, r, int(id))
+}
+
+func (app *application) writeCourseCategories(w http.ResponseWriter, r *http.Request, courseID int) {
+	categories, err := app.models.Categories.ListForCourse(courseID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCourseTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writeCourseTags(w, r, int(id))
+}
+
+// setCourseTagsHandler replaces the tags of the course.
+func (app *application) setCourseTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Tags []string `json:"tags"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tags := model.NormalizeTags(input.Tags)
+
+	v := validator.New()
+	if model.ValidateTags(v, tags); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tags.SetForCourse(int(id), tags, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCourseTags(w, r, int(id))
+}
+
+func (app *application) writeCourseTags(w http.ResponseWriter, r *http.Request, courseID int) {
+	tags, err := app.models.Tags.ListForCourse(courseID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// categoryErrorResponse reports an error returned by one of the
+// CategoryModel methods that change the tree.
+func (app *application) categoryErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, model.ErrDuplicateCategory):
+		v.AddError("name", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrCategoryNotFound):
+		v.AddError("parent_id", "must be an existing category")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrCategoryCycle):
+		v.AddError("parent_id", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrCategoryHasChildren):
+		app.errorResponse(w, r, http.StatusConflict, "the category has subcategories; move or delete them first")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
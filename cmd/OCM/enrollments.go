@@ -0,0 +1,275 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBulkEnrollment is how many students one request may enroll.
+const maxBulkEnrollment = 500
+
+func (app *application) listCourseEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	status, ok := app.readEnrollmentStatusFilter(w, r)
+	if !ok {
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.ListForCourse(int(id), status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createEnrollmentsHandler enrolls one student given as student_id, or
+// several at once given as student_ids. Students who have not completed
+// the prerequisites of the course are only let in with
+// override_prerequisites, which needs the enrollments:override permission.
+func (app *application) createEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		StudentID             *int  `json:"student_id"`
+		StudentIDs            []int `json:"student_ids"`
+		OverridePrerequisites bool  `json:"override_prerequisites"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	studentIDs := input.StudentIDs
+	if input.StudentID != nil {
+		studentIDs = append(studentIDs, *input.StudentID)
+	}
+
+	v := validator.New()
+	v.Check(len(studentIDs) > 0, "student_ids", "must be provided")
+	v.Check(len(studentIDs) <= maxBulkEnrollment, "student_ids", "must not contain more than "+strconv.Itoa(maxBulkEnrollment)+" students")
+	seen := make(map[int]bool, len(studentIDs))
+	for _, studentID := range studentIDs {
+		v.Check(studentID > 0, "student_ids", "must only contain positive integers")
+		v.Check(!seen[studentID], "student_ids", "must not contain duplicate values")
+		seen[studentID] = true
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkPrerequisiteOverride(w, r, input.OverridePrerequisites) {
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.Enroll(int(id), studentIDs, app.actor(r), input.OverridePrerequisites)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrAlreadyEnrolled), errors.Is(err, model.ErrStudentNotFound), errors.Is(err, model.ErrPrerequisitesNotMet):
+			v.AddError("student_ids", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.courseOwnershipErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateEnrollmentHandler changes the status of an enrollment. Like
+// enrolling, making a student active takes override_prerequisites if they
+// have not completed the prerequisites of the course.
+func (app *application) updateEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, studentID, ok := app.readEnrollmentParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status                string `json:"status"`
+		OverridePrerequisites bool   `json:"override_prerequisites"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateEnrollmentStatus(v, input.Status); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkPrerequisiteOverride(w, r, input.OverridePrerequisites) {
+		return
+	}
+
+	app.setEnrollmentStatus(w, r, courseID, studentID, input.Status, input.OverridePrerequisites)
+}
+
+// deleteEnrollmentHandler drops the student from the course. The
+// enrollment is kept with the dropped status.
+func (app *application) deleteEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, studentID, ok := app.readEnrollmentParams(w, r)
+	if !ok {
+		return
+	}
+
+	app.setEnrollmentStatus(w, r, courseID, studentID, model.EnrollmentDropped, false)
+}
+
+func (app *application) setEnrollmentStatus(w http.ResponseWriter, r *http.Request, courseID, studentID int, status string, overridePrerequisites bool) {
+	enrollment, promotions, err := app.models.Enrollments.SetStatus(courseID, studentID, status, app.actor(r), overridePrerequisites)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrCourseFull):
+			app.errorResponse(w, r, http.StatusConflict, "the course is full")
+		case errors.Is(err, model.ErrPrerequisitesNotMet):
+			v := validator.New()
+			v.AddError("status", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.courseOwnershipErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyWaitlistPromotions(promotions)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollment": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStudentCoursesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	status, ok := app.readEnrollmentStatusFilter(w, r)
+	if !ok {
+		return
+	}
+
+	_, err = app.models.Student.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.ListForStudent(int(id), status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkPrerequisiteOverride checks that the user may let students in
+// without the prerequisites, if they asked to. If it returns false a
+// response has already been written.
+func (app *application) checkPrerequisiteOverride(w http.ResponseWriter, r *http.Request, override bool) bool {
+	if !override {
+		return true
+	}
+
+	permitted, err := app.hasPermission(r, "enrollments:override")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+func (app *application) readEnrollmentParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	courseID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentId"])
+	if err != nil || studentID < 1 {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	return int(courseID), studentID, true
+}
+
+// readEnrollmentStatusFilter reads the optional status query parameter.
+func (app *application) readEnrollmentStatusFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	status := app.readString(r.URL.Query(), "status", "")
+	if status == "" {
+		return "", true
+	}
+
+	v := validator.New()
+	v.Check(validator.In(status, model.EnrollmentActive, model.EnrollmentWaitlisted, model.EnrollmentDropped, model.EnrollmentCompleted), "status", "must be active, waitlisted, dropped or completed")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+	return status, true
+}
+
+// notifyWaitlistPromotions emails the students who got a seat off the
+// waitlist. Students without a linked account have no address to write to.
+func (app *application) notifyWaitlistPromotions(promotions []*model.WaitlistPromotion) {
+	for _, promotion := range promotions {
+		if promotion.Email == "" {
+			continue
+		}
+		promotion := promotion
+		app.background(func() {
+			data := map[string]interface{}{
+				"studentName": promotion.StudentName,
+				"courseTitle": promotion.CourseTitle,
+			}
+			err := app.mailer.Send(promotion.Email, "waitlist_promotion.tmpl", data)
+			if err != nil {
+				app.logger.Println(err)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"OCM/pkg/OCM/model"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type envelope map[string]interface{}
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	app.logger.Printf("request %s: %v", app.contextGetRequestID(r), err)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
@@ -64,3 +68,28 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your role requires two-factor authentication, please enable it and log in again"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) bannedUserResponse(w http.ResponseWriter, r *http.Request, ban *model.Ban) {
+	env := envelope{
+		"error": "your user account has been banned",
+		"ban":   envelope{"reason": ban.Reason, "expiry": ban.Expiry},
+	}
+	err := app.writeJSON(w, http.StatusForbidden, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "your account is temporarily locked because of too many failed login attempts"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
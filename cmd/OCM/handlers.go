@@ -2,6 +2,7 @@ package main
 
 import (
 	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -33,9 +34,13 @@ func (app *application) HomeHandler(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) createCourseHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title          string `json:"title"`
-		Description    string `json:"description"`
-		CourseDuration string `json:"courseduration"`
+		Title          string      `json:"title"`
+		Description    string      `json:"description"`
+		CourseDuration string      `json:"courseduration"`
+		Capacity       *int        `json:"capacity"`
+		StartDate      *model.Date `json:"start_date"`
+		EndDate        *model.Date `json:"end_date"`
+		WeeklyHours    *float64    `json:"weekly_hours"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -48,9 +53,20 @@ func (app *application) createCourseHandler(w http.ResponseWriter, r *http.Reque
 		Title:          input.Title,
 		Description:    input.Description,
 		CourseDuration: input.CourseDuration,
+		Capacity:       input.Capacity,
+		StartDate:      input.StartDate,
+		EndDate:        input.EndDate,
+		WeeklyHours:    input.WeeklyHours,
 	}
 
-	err = app.models.Courses.Insert(course)
+	v := validator.New()
+	model.ValidateCourseCapacity(v, course)
+	if model.ValidateCourseSchedule(v, course); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Courses.Insert(course, app.contextGetUser(r).ID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
@@ -99,6 +115,12 @@ func (app *application) updateCourseHandler(w http.ResponseWriter, r *http.Reque
 		Title          *string `json:"title"`
 		Description    *string `json:"description"`
 		CourseDuration *string `json:"courseduration"`
+		// Capacity is raw so that null, which removes the limit, can be
+		// told apart from leaving it out.
+		Capacity    json.RawMessage `json:"capacity"`
+		StartDate   json.RawMessage `json:"start_date"`
+		EndDate     json.RawMessage `json:"end_date"`
+		WeeklyHours json.RawMessage `json:"weekly_hours"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -119,12 +141,46 @@ func (app *application) updateCourseHandler(w http.ResponseWriter, r *http.Reque
 		course.CourseDuration = *input.CourseDuration
 	}
 
-	err = app.models.Courses.Update(course)
+	nullable := []struct {
+		raw json.RawMessage
+		dst interface{}
+	}{
+		{input.Capacity, &course.Capacity},
+		{input.StartDate, &course.StartDate},
+		{input.EndDate, &course.EndDate},
+		{input.WeeklyHours, &course.WeeklyHours},
+	}
+	for _, field := range nullable {
+		if field.raw == nil {
+			continue
+		}
+		err = json.Unmarshal(field.raw, field.dst)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	model.ValidateCourseCapacity(v, course)
+	if model.ValidateCourseSchedule(v, course); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	promotions, err := app.models.Courses.Update(course, app.contextGetUser(r).ID)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrNotPermitted):
+			app.notPermittedResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
+	app.notifyWaitlistPromotions(promotions)
+
 	app.respondWithJSON(w, http.StatusOK, course)
 }
 
@@ -138,9 +194,16 @@ func (app *application) deleteCourseHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Courses.Delete(id)
+	err = app.models.Courses.Delete(id, app.actor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error (Probably course was not created)")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		case errors.Is(err, model.ErrNotPermitted):
+			app.notPermittedResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -167,13 +230,46 @@ func (app *application) listCoursesHandler(w http.ResponseWriter, r *http.Reques
 		pageSize = 10 // def value
 	}
 
-	courses, err := app.models.Courses.List(page, pageSize, filter, sort)
+	qs := r.URL.Query()
+	v := validator.New()
+	courseFilter := model.CourseFilter{
+		Title:        filter,
+		StartsAfter:  app.readDate(qs, "starts_after", v),
+		StartsBefore: app.readDate(qs, "starts_before", v),
+		Running:      app.readBool(qs, "running", v),
+		Tags:         model.NormalizeTags(app.readCSV(qs, "tag", nil)),
+	}
+	for _, category := range app.readCSV(qs, "category", nil) {
+		id, err := strconv.ParseInt(category, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("category", "must be a comma-separated list of category ids")
+			break
+		}
+		courseFilter.Categories = append(courseFilter.Categories, id)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	courses, err := app.models.Courses.List(page, pageSize, courseFilter, sort)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Server error")
 		return
 	}
 
-	app.respondWithJSON(w, http.StatusOK, courses)
+	// The facet counts let the catalog draw its filters without asking
+	// again.
+	facets, err := app.models.Courses.Facets(courseFilter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"courses": courses, "facets": facets}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) listCoursesHandlerWithOutFilters(w http.ResponseWriter, r *http.Request) {
@@ -291,9 +387,16 @@ func (app *application) AssignmentsById(w http.ResponseWriter, r *http.Request)
 		CourseId:    input.CourseId,
 	}
 
-	err = app.models.Assignments.InsertAssignment(assignment)
+	err = app.models.Assignments.InsertAssignment(assignment, app.contextGetUser(r).ID)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusUnprocessableEntity, "Course not found")
+		case errors.Is(err, model.ErrNotPermitted):
+			app.notPermittedResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -359,9 +462,16 @@ func (app *application) AssignmentUpdate(w http.ResponseWriter, r *http.Request)
 		assignment.CourseId = input.CourseId
 	}
 
-	err = app.models.Assignments.Update(assignment)
+	err = app.models.Assignments.Update(assignment, app.contextGetUser(r).ID)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusUnprocessableEntity, "Course not found")
+		case errors.Is(err, model.ErrNotPermitted):
+			app.notPermittedResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -378,9 +488,16 @@ func (app *application) AssigmentDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Assignments.Delete(id)
+	err = app.models.Assignments.Delete(id, app.actor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error (Probably course was not created)")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		case errors.Is(err, model.ErrNotPermitted):
+			app.notPermittedResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -503,7 +620,7 @@ func (app *application) deleteStudentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Student.Delete(id)
+	err = app.models.Student.Delete(id, app.actor(r))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			app.respondWithError(w, http.StatusNotFound, "Student not found")
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"OCM/pkg/OCM/auth"
 	"OCM/pkg/OCM/model"
 	"OCM/pkg/OCM/validator"
 	"context"
@@ -12,13 +13,20 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	accessTokenContextKey = contextKey("access_token")
+	sessionContextKey     = contextKey("session")
+	apiKeyContextKey      = contextKey("api_key")
+	requestIDContextKey   = contextKey("request_id")
+)
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	param := mux.Vars(r)["id"]
@@ -127,6 +135,49 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime parses an RFC 3339 timestamp from the query string.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+	return t
+}
+
+// readDate parses a date in model.DateLayout from the query string. It
+// returns nil if the key is missing.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) *model.Date {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	d, err := model.ParseDate(s)
+	if err != nil {
+		v.AddError(key, "must be a date in the form "+model.DateLayout)
+		return nil
+	}
+	return &d
+}
+
+// readBool parses a boolean from the query string.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return false
+	}
+	return b
+}
+
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
@@ -140,6 +191,75 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 	return user
 }
 
+func (app *application) contextSetAccessToken(r *http.Request, claims *auth.AccessTokenCustomClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), accessTokenContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetAccessToken returns the claims of the access token the request
+// was authenticated with, or nil if it wasn't authenticated with one.
+func (app *application) contextGetAccessToken(r *http.Request) *auth.AccessTokenCustomClaims {
+	claims, _ := r.Context().Value(accessTokenContextKey).(*auth.AccessTokenCustomClaims)
+	return claims
+}
+
+func (app *application) contextSetSession(r *http.Request, session *model.Session) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// contextGetSession returns the session whose refresh token was presented
+// to the refresh endpoint, or nil on any other request.
+func (app *application) contextGetSession(r *http.Request) *model.Session {
+	session, _ := r.Context().Value(sessionContextKey).(*model.Session)
+	return session
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *model.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with,
+// or nil if it wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *model.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*model.APIKey)
+	return key
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// hasPermission reports whether the user making the request has the
+// permission, for handlers where only part of what they do needs it. An
+// API key has to be scoped for it as well.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+	if key := app.contextGetAPIKey(r); key != nil && !key.Scopes.Allow(code) {
+		return false, nil
+	}
+	return permissions.Include(code), nil
+}
+
+// actor describes who is making the request, for the audit log.
+func (app *application) actor(r *http.Request) model.Actor {
+	return model.Actor{
+		UserID:    app.contextGetUser(r).ID,
+		IP:        clientIP(r),
+		RequestID: app.contextGetRequestID(r),
+	}
+}
+
 func (app *application) background(fn func()) {
 	// Launch a background goroutine.
 	go func() {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *application) listMyCoursesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	teaching, err := app.models.Courses.ListTaughtBy(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	enrolled, err := app.models.Courses.ListEnrolled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"teaching": teaching, "enrolled": enrolled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCourseInstructorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	instructors, err := app.models.Courses.Instructors(int(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"instructors": instructors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addCourseInstructorHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, instructorID, ok := app.readCourseInstructorParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Only users who are allowed to write courses at all can be made
+	// instructors of one.
+	permissions, err := app.models.Permissions.GetAllForUser(instructorID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permissions.Include("courses:write") {
+		v := validator.New()
+		v.AddError("userId", "user must have the instructor role")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Courses.AddInstructor(courseID, instructorID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	instructors, err := app.models.Courses.Instructors(courseID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"instructors": instructors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCourseInstructorHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, instructorID, ok := app.readCourseInstructorParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Courses.RemoveInstructor(courseID, instructorID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	instructors, err := app.models.Courses.Instructors(courseID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"instructors": instructors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readCourseInstructorParams(w http.ResponseWriter, r *http.Request) (int, int64, bool) {
+	courseID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	instructorID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil || instructorID < 1 {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	return int(courseID), instructorID, true
+}
+
+// courseOwnershipErrorResponse reports an error returned by one of the
+// model methods that check course ownership.
+func (app *application) courseOwnershipErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, model.ErrNotPermitted):
+		app.notPermittedResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
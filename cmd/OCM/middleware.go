@@ -2,11 +2,37 @@ package main
 
 import (
 	"OCM/pkg/OCM/model"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"net/http"
+	"regexp"
 	"strings"
 )
 
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags every request with an ID, taken from the X-Request-ID
+// header if the client or a proxy sent a sensible one. It is echoed in the
+// response and recorded in the audit log.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -25,25 +51,71 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 		}
 		token := headerParts[1]
 
-		if r.URL.Path == "/token/refresh" {
+		if r.URL.Path == "/api/token/refresh" {
 			userRefresh, err := app.auth.ValidateRefreshToken(token)
 			if err != nil {
-				app.serverErrorResponse(w, r, err)
+				app.invalidAuthenticationTokenResponse(w, r)
 				return
 			}
 
 			user, err := app.models.Users.GetByUsername(userRefresh.Username)
 			if err != nil {
-				app.serverErrorResponse(w, r, err)
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
 				return
 			}
 
+			// The token hash changes on logout from all sessions and on
+			// password reset, which invalidates every refresh token at once.
 			actualCustomKey := app.auth.GenerateCustomKey(user.Username, user.TokenHash)
 			if userRefresh.CustomKey != actualCustomKey {
 				app.invalidAuthenticationTokenResponse(w, r)
 				return
 			}
+
+			session, err := app.models.Sessions.Get(user.ID, userRefresh.SessionID)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			if userRefresh.ID != session.RefreshTokenID {
+				// A correctly signed, unexpired refresh token that is no
+				// longer the session's latest has already been used, so
+				// somebody is replaying it. End the session.
+				app.logger.Printf("refresh token reuse detected for user %s, ending session %d", user.Username, session.ID)
+				err = app.models.Sessions.Delete(user.ID, session.ID)
+				if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			r = app.contextSetUser(r, user)
+			r = app.contextSetSession(r, session)
+
+		} else if strings.HasPrefix(token, model.APIKeyPrefix) {
+			key, user, err := app.models.APIKeys.Authenticate(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
 			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
 
 		} else {
 			userAccess, err := app.auth.ValidateAccessToken(token)
@@ -56,11 +128,31 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 			user.Username = userAccess.Username
 			user.Email = userAccess.Email
 			user.Activated = userAccess.Activated
-			user.Role = userAccess.Role
 
 			r = app.contextSetUser(r, &user)
+			r = app.contextSetAccessToken(r, userAccess)
+
+			err = app.models.Sessions.Touch(userAccess.SessionID)
+			if err != nil {
+				app.logError(r, err)
+			}
 
 		}
+
+		// Bans take effect immediately rather than when the token is next
+		// minted. Banned users can still look up their own ban; anonymous
+		// users have none to look up.
+		if user := app.contextGetUser(r); !user.IsAnonymous() && r.URL.Path != "/api/me/ban" {
+			ban, err := app.models.Bans.GetActive(user.ID)
+			if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if ban != nil {
+				app.bannedUserResponse(w, r, ban)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -88,14 +180,86 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
-func (app *application) requireRole(allowedRoles model.Roles, next http.HandlerFunc) http.HandlerFunc {
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		role := user.Role
-		fmt.Println(role)
-		fmt.Println(user)
-		if !allowedRoles.Include(user.Role) {
-			app.logger.Printf("User %s does not have the required role to do that.", user.Username)
+
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			app.logger.Printf("User %s does not have the %s permission.", user.Username, code)
+			app.notPermittedResponse(w, r)
+			return
+		}
+		if key := app.contextGetAPIKey(r); key != nil && !key.Scopes.Allow(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		// Roles that require 2FA only grant their permissions to sessions
+		// that were started with a second factor, and to API keys such a
+		// session created.
+		mfa := true
+		if claims := app.contextGetAccessToken(r); claims != nil {
+			mfa = claims.MFA
+		} else if key := app.contextGetAPIKey(r); key != nil {
+			mfa = key.MFA
+		}
+		if !mfa {
+			required, err := app.models.Roles.RequiresTwoFactor(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if required {
+				app.twoFactorRequiredResponse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return app.requireActivatedUser(fn)
+}
+
+// requireStudentOrPermission lets students through to their own record,
+// identified by the id route parameter. Everyone else needs the permission.
+func (app *application) requireStudentOrPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	withPermission := app.requirePermission(code, next)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		student, err := app.models.Student.GetForUser(app.contextGetUser(r).ID)
+		if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if student == nil || int64(student.StudentID) != id {
+			withPermission(w, r)
+			return
+		}
+
+		if key := app.contextGetAPIKey(r); key != nil && !key.Scopes.Allow(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return app.requireActivatedUser(fn)
+}
+
+// requireAccessToken keeps API keys away from account management: the
+// request must come from a user who logged in.
+func (app *application) requireAccessToken(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+)
+
+func (app *application) showCourseOutlineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	outline, err := app.models.Courses.Outline(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"outline": outline}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listModulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	modules, err := app.models.Modules.ListForCourse(int(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"modules": modules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createModuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	module := &model.Module{CourseID: int(id), Title: input.Title}
+
+	v := validator.New()
+	if model.ValidateModule(v, module); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Modules.Insert(module, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"module": module}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) reorderModulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Modules.Reorder(int(id), input.IDs, app.contextGetUser(r).ID)
+	if err != nil {
+		app.reorderErrorResponse(w, r, err, "must list every module of the course exactly once")
+		return
+	}
+
+	modules, err := app.models.Modules.ListForCourse(int(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"modules": modules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showModuleHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"module": module}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateModuleHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title *string `json:"title"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		module.Title = *input.Title
+	}
+
+	v := validator.New()
+	if model.ValidateModule(v, module); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Modules.Update(module, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module": module}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteModuleHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Modules.Delete(module, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "module successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLessonsHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	lessons, err := app.models.Lessons.ListForModule(module.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lessons": lessons}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createLessonHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	lesson := &model.Lesson{ModuleID: module.ID, Title: input.Title, Body: input.Body}
+
+	v := validator.New()
+	if model.ValidateLesson(v, lesson); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lessons.Insert(lesson, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"lesson": lesson}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) reorderLessonsHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModule(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Lessons.Reorder(module.ID, input.IDs, app.contextGetUser(r).ID)
+	if err != nil {
+		app.reorderErrorResponse(w, r, err, "must list every lesson of the module exactly once")
+		return
+	}
+
+	lessons, err := app.models.Lessons.ListForModule(module.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lessons": lessons}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLessonHandler(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := app.readLesson(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"lesson": lesson}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLessonHandler(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := app.readLesson(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title *string `json:"title"`
+		Body  *string `json:"body"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		lesson.Title = *input.Title
+	}
+	if input.Body != nil {
+		lesson.Body = *input.Body
+	}
+
+	v := validator.New()
+	if model.ValidateLesson(v, lesson); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lessons.Update(lesson, app.contextGetUser(r).ID)
+	if err != nil {
+		app.lessonErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lesson": lesson}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteLessonHandler(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := app.readLesson(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Lessons.Delete(lesson, app.contextGetUser(r).ID)
+	if err != nil {
+		app.lessonErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "lesson successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moveLessonHandler puts a lesson at a position of the same or another
+// module. Leaving out the module keeps the lesson where it is, leaving out
+// the position puts it last.
+func (app *application) moveLessonHandler(w http.ResponseWriter, r *http.Request) {
+	lesson, ok := app.readLesson(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ModuleID *int64 `json:"module_id"`
+		Position *int   `json:"position"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	moduleID := lesson.ModuleID
+	if input.ModuleID != nil {
+		moduleID = *input.ModuleID
+	}
+	position := int(^uint(0) >> 1)
+	if input.Position != nil {
+		position = *input.Position
+	}
+
+	v := validator.New()
+	v.Check(moduleID > 0, "module_id", "must be a positive integer")
+	v.Check(position > 0, "position", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lessons.Move(lesson, moduleID, position, app.contextGetUser(r).ID)
+	if err != nil {
+		app.lessonErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lesson": lesson}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readModule(w http.ResponseWriter, r *http.Request) (*model.Module, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	module, err := app.models.Modules.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return module, true
+}
+
+func (app *application) readLesson(w http.ResponseWriter, r *http.Request) (*model.Lesson, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	lesson, err := app.models.Lessons.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return lesson, true
+}
+
+func (app *application) reorderErrorResponse(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, model.ErrInvalidOrder):
+		v := validator.New()
+		v.AddError("ids", message)
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.courseOwnershipErrorResponse(w, r, err)
+	}
+}
+
+// lessonErrorResponse reports an error from a lesson model method. An edit
+// conflict means the lesson was moved or deleted since it was read.
+func (app *application) lessonErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.courseOwnershipErrorResponse(w, r, err)
+	}
+}
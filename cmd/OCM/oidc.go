@@ -0,0 +1,218 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/oidc"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// oidcLoginTTL is how long a user has to finish logging in at the identity
+// provider.
+const oidcLoginTTL = 10 * time.Minute
+
+var usernameCleanRX = regexp.MustCompile(`[^a-z_0-9]+`)
+
+// startOIDCLoginHandler begins a login at the identity provider. The client
+// sends the user to authorization_url, and once the provider redirects back
+// posts the code and state to completeOIDCLoginHandler. The client should
+// check that the state it gets back is the one returned here.
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var login model.OIDCLogin
+	var err error
+	for _, field := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*field, err = oidc.GenerateRandom()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	login.Expiry = time.Now().Add(oidcLoginTTL)
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.InsertLogin(&login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authURL, "state": login.State}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeOIDCLoginHandler redeems the authorization code, finds or creates
+// the matching user and then logs them in like a password login would.
+func (app *application) completeOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.Identities.TakeLogin(input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	idToken, err := app.oidc.Exchange(r.Context(), input.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		app.logger.Printf("OIDC login failed: %v", err)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.userForIdentity(idToken, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v.AddError("email", "your identity provider has not verified your email address")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A locked account stays locked whichever way the user logs in.
+	if !app.checkLoginState(w, r, user) {
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+var errUnverifiedEmail = errors.New("email not verified by identity provider")
+
+// userForIdentity returns the user linked to the ID token's subject. The
+// first time a subject logs in it is linked to the user with the same
+// email address, or to a new user, but only if the provider has verified
+// that address.
+func (app *application) userForIdentity(idToken *oidc.IDToken, actor model.Actor) (*model.User, error) {
+	issuer := app.oidc.Issuer()
+
+	user, err := app.models.Identities.GetUser(issuer, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, model.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !idToken.EmailVerified || idToken.Email == "" {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(idToken.Email)
+	switch {
+	case err == nil:
+	case errors.Is(err, model.ErrRecordNotFound):
+		user, err = app.createOIDCUser(idToken, actor)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// The provider vouches for the address, which is all activation proves.
+	if !user.Activated {
+		err = app.models.Users.ActivateUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		user.Activated = true
+	}
+
+	err = app.models.Identities.Link(user.ID, issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	app.logger.Printf("linked %s identity %s to user %s", issuer, idToken.Subject, user.Username)
+	return user, nil
+}
+
+// createOIDCUser registers a user for someone who only ever logs in through
+// the identity provider. They get a random password they can replace with
+// a password reset.
+func (app *application) createOIDCUser(idToken *oidc.IDToken, actor model.Actor) (*model.User, error) {
+	base := idToken.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	base = usernameCleanRX.ReplaceAllString(strings.ToLower(base), "_")
+	if len(base) < 3 {
+		base = "user_" + base
+	}
+	if len(base) > 30 {
+		base = base[:30]
+	}
+
+	user := &model.User{
+		Email:     idToken.Email,
+		TokenHash: app.auth.GenerateRandomString(15),
+	}
+	password, err := oidc.GenerateRandom()
+	if err != nil {
+		return nil, err
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		user.Username = base
+		if i > 0 {
+			user.Username = fmt.Sprintf("%s_%d", base, i+1)
+		}
+		err = app.models.Users.Insert(user, actor)
+		if !errors.Is(err, model.ErrDuplicateUsername) || i == 9 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
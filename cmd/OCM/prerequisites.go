@@ -0,0 +1,95 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// listPrerequisitesHandler returns every course that has to be completed
+// before the course, not only its direct prerequisites.
+func (app *application) listPrerequisitesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Courses.Get(int(id))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writePrerequisites(w, r, int(id))
+}
+
+func (app *application) addPrerequisiteHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, prerequisiteID, ok := app.readCoursePrerequisiteParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Prerequisites.Add(courseID, prerequisiteID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrPrerequisiteCycle):
+			app.errorResponse(w, r, http.StatusConflict, "adding this prerequisite would create a cycle")
+		case errors.Is(err, model.ErrPrerequisiteNotFound):
+			v := validator.New()
+			v.AddError("prerequisiteId", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.courseOwnershipErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writePrerequisites(w, r, courseID)
+}
+
+func (app *application) removePrerequisiteHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, prerequisiteID, ok := app.readCoursePrerequisiteParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Prerequisites.Remove(courseID, prerequisiteID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.courseOwnershipErrorResponse(w, r, err)
+		return
+	}
+
+	app.writePrerequisites(w, r, courseID)
+}
+
+func (app *application) writePrerequisites(w http.ResponseWriter, r *http.Request, courseID int) {
+	prerequisites, err := app.models.Prerequisites.List(courseID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"prerequisites": prerequisites}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readCoursePrerequisiteParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	courseID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	prerequisiteID, err := strconv.Atoi(mux.Vars(r)["prerequisiteId"])
+	if err != nil || prerequisiteID < 1 {
+		app.notFoundResponse(w, r)
+		return 0, 0, false
+	}
+	return int(courseID), prerequisiteID, true
+}
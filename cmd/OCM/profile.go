@@ -0,0 +1,367 @@
+package main
+
+import (
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+)
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeUserDetails(w, r, app.contextGetUser(r).ID)
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Username *string `json:"username"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Username != nil {
+		user.Username = *input.Username
+	}
+
+	v := validator.New()
+	if model.ValidateUsername(v, user.Username); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeProfileUpdate(w, r, user, false)
+}
+
+// changePasswordHandler sets a new password for a user who knows the
+// current one. All other sessions are ended and the user's API keys are
+// revoked.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	model.ValidatePasswordField(v, "new_password", input.NewPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readCurrentPassword(w, r, "current_password", input.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	v.Check(input.NewPassword != input.CurrentPassword, "new_password", "must be different from your current password")
+	if app.checkPasswordPolicy(v, "new_password", input.NewPassword, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// A new token hash invalidates pending 2FA challenges for the old
+	// password.
+	user.TokenHash = app.auth.GenerateRandomString(15)
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeProfileUpdate(w, r, user, true)
+}
+
+// requestEmailChangeHandler sends a code to the new address. The address
+// is only changed once the code comes back to confirmEmailChangeHandler.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readCurrentPassword(w, r, "password", input.Password)
+	if !ok {
+		return
+	}
+
+	if input.Email == user.Email {
+		v.AddError("email", "is already your email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only the most recently requested change stays valid.
+	err = app.models.Verifications.Delete(user.ID, model.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verification, err := app.models.Verifications.NewWithPayload(user.ID, emailChangeCodeTTL, model.ScopeEmailChange, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"emailChangeCode": verification.PlainText,
+		}
+		err := app.mailer.Send(input.Email, "user_email_change.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to your new address containing confirmation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateVerificationCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByVerificationCode(model.ScopeEmailChange, input.Code)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if user == nil || user.ID != app.contextGetUser(r).ID {
+		v.AddError("code", "invalid or expired email change code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	verification, err := app.models.Verifications.GetByUserID(user.ID, model.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if verification == nil {
+		v.AddError("code", "invalid or expired email change code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = verification.Payload
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"newEmail": user.Email,
+		}
+		err := app.mailer.Send(oldEmail, "user_email_changed.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+
+	app.writeProfileUpdate(w, r, user, true)
+}
+
+// checkPasswordPolicy adds an error for key to v if the new password of
+// the user breaks the password policy. Length is checked separately, and
+// the policy is only applied once the length is right.
+func (app *application) checkPasswordPolicy(v *validator.Validator, key, plaintext string, user *model.User) {
+	if _, failed := v.Errors[key]; failed {
+		return
+	}
+	app.passwords.Check(v, key, plaintext, user.Username, user.Email)
+}
+
+// readCurrentPassword loads the current user and checks their password.
+// Wrong guesses are slowed down like failed logins and reported against
+// field. If it returns false a response has already been written.
+func (app *application) readCurrentPassword(w http.ResponseWriter, r *http.Request, field, password string) (*model.User, bool) {
+	ip := clientIP(r)
+	if wait := app.loginFailures.wait(ip); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !match {
+		app.loginFailures.fail(ip)
+		v := validator.New()
+		v.AddError(field, "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	return user, true
+}
+
+// writeProfileUpdate responds to a change of the user's username, email or
+// password. Tokens carry the username and email and refresh tokens are
+// bound to the username and password, so the current session gets a new
+// token pair. After a change of password or email every other session is
+// ended as well, since it may belong to whoever the change locks out.
+func (app *application) writeProfileUpdate(w http.ResponseWriter, r *http.Request, user *model.User, endOtherSessions bool) {
+	claims := app.contextGetAccessToken(r)
+
+	session, err := app.models.Sessions.Get(user.ID, claims.SessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if endOtherSessions {
+		err = app.models.Sessions.DeleteAllForUserExcept(user.ID, session.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	roles, err := app.models.Roles.GetUserRoles(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.issueTokens(user, roles, session)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	details, err := app.models.Users.GetDetails(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env["user"] = details
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
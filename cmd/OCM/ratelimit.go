@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rps requests per second on average with bursts of up
+// to burst requests.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time, rps float64, burst int) bool {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.lastSeen).Seconds()*rps)
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*tokenBucket)
+	)
+
+	// Forget clients that haven't been seen for a while.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			mu.Lock()
+			for ip, client := range clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		now := time.Now()
+
+		mu.Lock()
+		client, found := clients[ip]
+		if !found {
+			client = &tokenBucket{tokens: float64(app.config.limiter.burst), lastSeen: now}
+			clients[ip] = client
+		}
+		allowed := client.allow(now, app.config.limiter.rps, app.config.limiter.burst)
+		mu.Unlock()
+
+		if !allowed {
+			app.rateLimitExceededResponse(w, r, time.Duration(float64(time.Second)/app.config.limiter.rps))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientLimiter gives every client IP its own token bucket. It is used for
+// endpoints that send emails, which need a much lower limit than the rest.
+type clientLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+	rps     float64
+	burst   int
+}
+
+func newClientLimiter(rps float64, burst int) *clientLimiter {
+	l := &clientLimiter{
+		clients: make(map[string]*tokenBucket),
+		rps:     rps,
+		burst:   burst,
+	}
+
+	// Forget clients whose bucket has filled up again.
+	full := time.Duration(float64(burst) / rps * float64(time.Second))
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+			for ip, client := range l.clients {
+				if time.Since(client.lastSeen) > full {
+					delete(l.clients, ip)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// wait takes a token for the client and returns 0, or returns how long the
+// client has to wait for the next token.
+func (l *clientLimiter) wait(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	client, found := l.clients[ip]
+	if !found {
+		client = &tokenBucket{tokens: float64(l.burst), lastSeen: now}
+		l.clients[ip] = client
+	}
+	if client.allow(now, l.rps, l.burst) {
+		return 0
+	}
+	return time.Duration((1 - client.tokens) / l.rps * float64(time.Second))
+}
+
+// loginFailures tracks failed logins per client IP so that a single client
+// can't try passwords against many accounts at full speed.
+type loginFailures struct {
+	mu      sync.Mutex
+	clients map[string]*failedLogins
+	base    time.Duration
+	max     time.Duration
+	window  time.Duration
+}
+
+type failedLogins struct {
+	count int
+	last  time.Time
+}
+
+func newLoginFailures(base, max, window time.Duration) *loginFailures {
+	f := &loginFailures{
+		clients: make(map[string]*failedLogins),
+		base:    base,
+		max:     max,
+		window:  window,
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			f.mu.Lock()
+			for ip, client := range f.clients {
+				if time.Since(client.last) > f.window {
+					delete(f.clients, ip)
+				}
+			}
+			f.mu.Unlock()
+		}
+	}()
+
+	return f
+}
+
+// wait returns how long the client has to wait before it may try again.
+func (f *loginFailures) wait(ip string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	client, found := f.clients[ip]
+	if !found || time.Since(client.last) > f.window {
+		return 0
+	}
+	return time.Until(client.last.Add(backoff(client.count, f.base, f.max)))
+}
+
+func (f *loginFailures) fail(ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	client, found := f.clients[ip]
+	if !found || time.Since(client.last) > f.window {
+		client = &failedLogins{}
+		f.clients[ip] = client
+	}
+	client.count++
+	client.last = time.Now()
+}
+
+// backoff doubles the delay with every failure, starting at base and
+// capped at max.
+func backoff(failures int, base, max time.Duration) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	if failures > 30 {
+		return max
+	}
+	delay := base << (failures - 1)
+	if delay > max || delay <= 0 {
+		return max
+	}
+	return delay
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
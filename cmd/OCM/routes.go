@@ -10,45 +10,161 @@ func (app *application) routes() http.Handler {
 	v := mux.NewRouter()
 	// Prefix
 	r := v.PathPrefix("/api").Subrouter()
+
+	// Public keys for verifying tokens
+	v.HandleFunc("/.well-known/jwks.json", app.jwksHandler).Methods("GET")
+
 	// Healthcheck
 	r.HandleFunc("/healthcheck", app.healthcheckHandler).Methods("GET")
 
 	// Courses
-	r.HandleFunc("/courses", app.requireActivatedUser(app.listCoursesHandlerWithOutFilters)).Methods("GET")
-	r.HandleFunc("/courses/{id}", app.requireRole([]string{"admin"}, app.updateCourseHandler)).Methods("PUT")
-	r.HandleFunc("/courses", app.createCourseHandler).Methods("POST")
-	r.HandleFunc("/courses/{id}", app.requireRole([]string{"admin"}, app.deleteCourseHandler)).Methods("DELETE")
+	r.HandleFunc("/courses", app.requirePermission("courses:read", app.listCoursesHandlerWithOutFilters)).Methods("GET")
+	r.HandleFunc("/courses/{id}", app.requirePermission("courses:write", app.updateCourseHandler)).Methods("PUT")
+	r.HandleFunc("/courses", app.requirePermission("courses:write", app.createCourseHandler)).Methods("POST")
+	r.HandleFunc("/courses/{id}", app.requirePermission("courses:delete", app.deleteCourseHandler)).Methods("DELETE")
 
 	// Courses - filter/pagination/sort
-	r.HandleFunc("/coursess", app.listCoursesHandler).Methods("GET")
+	r.HandleFunc("/coursess", app.requirePermission("courses:read", app.listCoursesHandler)).Methods("GET")
 
 	// Combined
-	r.HandleFunc("/courses/{id}/assignments", app.listAssignmentsByCourse).Methods("GET")
-	r.HandleFunc("/courses/{id:[0-9]+}/students", app.listStudentsByCourse).Methods("GET")
+	r.HandleFunc("/courses/{id}/assignments", app.requirePermission("assignments:read", app.listAssignmentsByCourse)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/students", app.requirePermission("students:read", app.listStudentsByCourse)).Methods("GET")
+
+	// Course instructors
+	r.HandleFunc("/courses/{id:[0-9]+}/instructors", app.requirePermission("courses:read", app.listCourseInstructorsHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/instructors/{userId:[0-9]+}", app.requirePermission("courses:write", app.addCourseInstructorHandler)).Methods("PUT")
+	r.HandleFunc("/courses/{id:[0-9]+}/instructors/{userId:[0-9]+}", app.requirePermission("courses:write", app.removeCourseInstructorHandler)).Methods("DELETE")
+
+	// Categories and tags
+	r.HandleFunc("/categories", app.requirePermission("courses:read", app.listCategoriesHandler)).Methods("GET")
+	r.HandleFunc("/categories", app.requirePermission("categories:write", app.createCategoryHandler)).Methods("POST")
+	r.HandleFunc("/categories/{id:[0-9]+}", app.requirePermission("categories:write", app.updateCategoryHandler)).Methods("PUT")
+	r.HandleFunc("/categories/{id:[0-9]+}", app.requirePermission("categories:write", app.deleteCategoryHandler)).Methods("DELETE")
+	r.HandleFunc("/courses/{id:[0-9]+}/categories", app.requirePermission("courses:read", app.listCourseCategoriesHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/categories", app.requirePermission("courses:write", app.setCourseCategoriesHandler)).Methods("PUT")
+	r.HandleFunc("/courses/{id:[0-9]+}/tags", app.requirePermission("courses:read", app.listCourseTagsHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/tags", app.requirePermission("courses:write", app.setCourseTagsHandler)).Methods("PUT")
+
+	// Course prerequisites
+	r.HandleFunc("/courses/{id:[0-9]+}/prerequisites", app.requirePermission("courses:read", app.listPrerequisitesHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/prerequisites/{prerequisiteId:[0-9]+}", app.requirePermission("courses:write", app.addPrerequisiteHandler)).Methods("PUT")
+	r.HandleFunc("/courses/{id:[0-9]+}/prerequisites/{prerequisiteId:[0-9]+}", app.requirePermission("courses:write", app.removePrerequisiteHandler)).Methods("DELETE")
+
+	// Enrollments
+	r.HandleFunc("/courses/{id:[0-9]+}/enrollments", app.requirePermission("students:read", app.listCourseEnrollmentsHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/enrollments", app.requirePermission("courses:write", app.createEnrollmentsHandler)).Methods("POST")
+	r.HandleFunc("/courses/{id:[0-9]+}/enrollments/{studentId:[0-9]+}", app.requirePermission("courses:write", app.updateEnrollmentHandler)).Methods("PATCH")
+	r.HandleFunc("/courses/{id:[0-9]+}/enrollments/{studentId:[0-9]+}", app.requirePermission("courses:write", app.deleteEnrollmentHandler)).Methods("DELETE")
+	r.HandleFunc("/students/{id:[0-9]+}/courses", app.requireStudentOrPermission("students:read", app.listStudentCoursesHandler)).Methods("GET")
+
+	// Course content
+	r.HandleFunc("/courses/{id:[0-9]+}/outline", app.requirePermission("courses:read", app.showCourseOutlineHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/modules", app.requirePermission("courses:read", app.listModulesHandler)).Methods("GET")
+	r.HandleFunc("/courses/{id:[0-9]+}/modules", app.requirePermission("courses:write", app.createModuleHandler)).Methods("POST")
+	r.HandleFunc("/courses/{id:[0-9]+}/modules/order", app.requirePermission("courses:write", app.reorderModulesHandler)).Methods("PUT")
+	r.HandleFunc("/modules/{id:[0-9]+}", app.requirePermission("courses:read", app.showModuleHandler)).Methods("GET")
+	r.HandleFunc("/modules/{id:[0-9]+}", app.requirePermission("courses:write", app.updateModuleHandler)).Methods("PUT")
+	r.HandleFunc("/modules/{id:[0-9]+}", app.requirePermission("courses:write", app.deleteModuleHandler)).Methods("DELETE")
+	r.HandleFunc("/modules/{id:[0-9]+}/lessons", app.requirePermission("courses:read", app.listLessonsHandler)).Methods("GET")
+	r.HandleFunc("/modules/{id:[0-9]+}/lessons", app.requirePermission("courses:write", app.createLessonHandler)).Methods("POST")
+	r.HandleFunc("/modules/{id:[0-9]+}/lessons/order", app.requirePermission("courses:write", app.reorderLessonsHandler)).Methods("PUT")
+	r.HandleFunc("/lessons/{id:[0-9]+}", app.requirePermission("courses:read", app.showLessonHandler)).Methods("GET")
+	r.HandleFunc("/lessons/{id:[0-9]+}", app.requirePermission("courses:write", app.updateLessonHandler)).Methods("PUT")
+	r.HandleFunc("/lessons/{id:[0-9]+}", app.requirePermission("courses:write", app.deleteLessonHandler)).Methods("DELETE")
+	r.HandleFunc("/lessons/{id:[0-9]+}/position", app.requirePermission("courses:write", app.moveLessonHandler)).Methods("PUT")
 
 	// Assignments
-	r.HandleFunc("/assignments", app.requireActivatedUser(app.listAssignmnetsWithoutFilters)).Methods("GET")
-	r.HandleFunc("/assignments", app.AssignmentsById).Methods("POST")
-	r.HandleFunc("/assignments/{id}", app.AssignmentUpdate).Methods("PUT")
-	r.HandleFunc("/assignments/{id}", app.AssigmentDelete).Methods("DELETE")
+	r.HandleFunc("/assignments", app.requirePermission("assignments:read", app.listAssignmnetsWithoutFilters)).Methods("GET")
+	r.HandleFunc("/assignments", app.requirePermission("assignments:write", app.AssignmentsById)).Methods("POST")
+	r.HandleFunc("/assignments/{id}", app.requirePermission("assignments:write", app.AssignmentUpdate)).Methods("PUT")
+	r.HandleFunc("/assignments/{id}", app.requirePermission("assignments:write", app.AssigmentDelete)).Methods("DELETE")
 
 	// Assignments - filter/pagination/sort
-	r.HandleFunc("/assignmentss", app.listAssignmentsHandler).Methods("GET")
+	r.HandleFunc("/assignmentss", app.requirePermission("assignments:read", app.listAssignmentsHandler)).Methods("GET")
 
 	// Student
-	r.HandleFunc("/students/{id}", app.getStudentHandler).Methods("GET")
-	r.HandleFunc("/students", app.createStudentHandler).Methods("POST")
-	r.HandleFunc("/students/{id}", app.updateStudentHandler).Methods("PUT")
-	r.HandleFunc("/students/{id}", app.deleteStudentHandler).Methods("DELETE")
+	r.HandleFunc("/students/{id}", app.requireStudentOrPermission("students:read", app.getStudentHandler)).Methods("GET")
+	r.HandleFunc("/students", app.requirePermission("students:write", app.createStudentHandler)).Methods("POST")
+	r.HandleFunc("/students/{id}", app.requirePermission("students:write", app.updateStudentHandler)).Methods("PUT")
+	r.HandleFunc("/students/{id}", app.requirePermission("students:write", app.deleteStudentHandler)).Methods("DELETE")
 
 	// Student - filter/pagination/sort
-	r.HandleFunc("/studentss", app.listStudentsHandler).Methods("GET")
+	r.HandleFunc("/studentss", app.requirePermission("students:read", app.listStudentsHandler)).Methods("GET")
 
 	// user auth
 	r.HandleFunc("/users", app.registerUserHandler).Methods("POST")
+	r.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
+	r.HandleFunc("/users/activation", app.resendActivationHandler).Methods("POST")
+	r.HandleFunc("/users/password-reset", app.createPasswordResetCodeHandler).Methods("POST")
+	r.HandleFunc("/users/password", app.resetUserPasswordHandler).Methods("PUT")
+	r.HandleFunc("/users/unlocked", app.unlockUserHandler).Methods("PUT")
 
 	// Authenticate new user
 	r.HandleFunc("/login", app.createAuthTokenHandler).Methods("POST")
+	r.HandleFunc("/login/2fa", app.loginTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/oidc/login", app.startOIDCLoginHandler).Methods("POST")
+	r.HandleFunc("/oidc/callback", app.completeOIDCLoginHandler).Methods("POST")
+	r.HandleFunc("/token/refresh", app.requireAuthenticatedUser(app.refreshTokenHandler)).Methods("POST")
+	r.HandleFunc("/logout", app.requireAuthenticatedUser(app.logoutHandler)).Methods("POST")
+	r.HandleFunc("/logout/all", app.requireAuthenticatedUser(app.logoutAllHandler)).Methods("POST")
+
+	// Admin - user management
+	r.HandleFunc("/admin/users", app.requirePermission("users:read", app.listUsersHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", app.requirePermission("users:read", app.showUserHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", app.requirePermission("users:write", app.deleteUserHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/activation", app.requirePermission("users:write", app.updateUserActivationHandler)).Methods("PUT")
+
+	// Admin - login lockouts
+	r.HandleFunc("/admin/lockouts", app.requirePermission("users:read", app.listLockoutsHandler)).Methods("GET")
+	r.HandleFunc("/admin/lockouts/{id:[0-9]+}", app.requirePermission("users:write", app.clearLockoutHandler)).Methods("DELETE")
+
+	// Admin - roles
+	r.HandleFunc("/admin/roles", app.requirePermission("users:read", app.listRolesHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/roles/{role}", app.requirePermission("roles:write", app.grantRoleHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/roles/{role}", app.requirePermission("roles:write", app.revokeRoleHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/roles/{role}/2fa", app.requirePermission("roles:write", app.updateRoleTwoFactorHandler)).Methods("PUT")
+
+	// Admin - student accounts
+	r.HandleFunc("/admin/students/{id:[0-9]+}/user", app.requirePermission("students:write", app.linkStudentHandler)).Methods("PUT")
+	r.HandleFunc("/admin/students/{id:[0-9]+}/user", app.requirePermission("students:write", app.unlinkStudentHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/students/{id:[0-9]+}/invitations", app.requirePermission("students:write", app.inviteStudentHandler)).Methods("POST")
+
+	// Admin - audit log
+	r.HandleFunc("/admin/audit", app.requirePermission("audit:read", app.listAuditHandler)).Methods("GET")
+
+	// Admin - bans
+	r.HandleFunc("/admin/bans", app.requirePermission("bans:write", app.listBansHandler)).Methods("GET")
+	r.HandleFunc("/admin/bans", app.requirePermission("bans:write", app.createBanHandler)).Methods("POST")
+	r.HandleFunc("/admin/bans/{id:[0-9]+}", app.requirePermission("bans:write", app.liftBanHandler)).Methods("DELETE")
+
+	// Current user - profile
+	r.HandleFunc("/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler)).Methods("GET")
+	r.HandleFunc("/users/me", app.requireAccessToken(app.updateCurrentUserHandler)).Methods("PATCH")
+	r.HandleFunc("/users/me/password", app.requireAccessToken(app.changePasswordHandler)).Methods("PUT")
+	r.HandleFunc("/users/me/email", app.requireAccessToken(app.requestEmailChangeHandler)).Methods("POST")
+	r.HandleFunc("/users/me/email", app.requireAccessToken(app.confirmEmailChangeHandler)).Methods("PUT")
+
+	// Current user
+	r.HandleFunc("/me/ban", app.requireAuthenticatedUser(app.showBanStatusHandler)).Methods("GET")
+	r.HandleFunc("/me/courses", app.requireActivatedUser(app.listMyCoursesHandler)).Methods("GET")
+	r.HandleFunc("/me/student", app.requireActivatedUser(app.showMyStudentHandler)).Methods("GET")
+	r.HandleFunc("/me/student", app.requireAccessToken(app.acceptStudentInvitationHandler)).Methods("PUT")
+
+	// Current user - two-factor authentication
+	r.HandleFunc("/me/2fa", app.requireAccessToken(app.enableTwoFactorHandler)).Methods("POST")
+	r.HandleFunc("/me/2fa", app.requireAccessToken(app.disableTwoFactorHandler)).Methods("DELETE")
+	r.HandleFunc("/me/2fa/confirm", app.requireAccessToken(app.confirmTwoFactorHandler)).Methods("POST")
+	r.HandleFunc("/me/2fa/recovery-codes", app.requireAccessToken(app.regenerateRecoveryCodesHandler)).Methods("POST")
+
+	// Current user - sessions
+	r.HandleFunc("/me/sessions", app.requireAccessToken(app.listSessionsHandler)).Methods("GET")
+	r.HandleFunc("/me/sessions/{id:[0-9]+}", app.requireAccessToken(app.revokeSessionHandler)).Methods("DELETE")
+
+	// Current user - API keys
+	r.HandleFunc("/me/api-keys", app.requireAccessToken(app.listAPIKeysHandler)).Methods("GET")
+	r.HandleFunc("/me/api-keys", app.requireAccessToken(app.createAPIKeyHandler)).Methods("POST")
+	r.HandleFunc("/me/api-keys/{id:[0-9]+}/rotate", app.requireAccessToken(app.rotateAPIKeyHandler)).Methods("POST")
+	r.HandleFunc("/me/api-keys/{id:[0-9]+}", app.requireAccessToken(app.revokeAPIKeyHandler)).Methods("DELETE")
 
-	return r
+	return v
 }
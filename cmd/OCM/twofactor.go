@@ -0,0 +1,383 @@
+package main
+
+import (
+	"OCM/pkg/OCM/auth"
+	"OCM/pkg/OCM/model"
+	"OCM/pkg/OCM/validator"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// twoFactorIssuer is the name authenticator apps show next to the code.
+const twoFactorIssuer = "GoUnion"
+
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			v := validator.New()
+			v.AddError("2fa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":           secret,
+		"provisioning_uri": auth.TOTPProvisioningURI(twoFactorIssuer, user.Email, secret),
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler turns 2FA on once the user proves their
+// authenticator app produces the right codes and gives their password, so
+// that a stolen token can't tie the account to someone else's app. All other
+// sessions are ended and a new token pair for a 2FA session is returned with
+// the recovery codes.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidateVerificationCode(v, input.Code)
+	v.Check(input.Password != "", "password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readCurrentPassword(w, r, "password", input.Password)
+	if !ok {
+		return
+	}
+
+	twoFactor, err := app.models.Users.GetTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor.Enabled {
+		v.AddError("2fa", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if twoFactor.Secret == "" {
+		v.AddError("2fa", "two-factor enrollment has not been started")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(twoFactor.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := app.models.Users.EnableTwoFactor(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			v.AddError("2fa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.RotateTokenHash(user, app.auth.GenerateRandomString(15))
+	if err != nil && !errors.Is(err, model.ErrEditConflict) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.startSession(r, user, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env["recovery_codes"] = recoveryCodes
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if !app.readSecondFactor(w, r, user) {
+		return
+	}
+
+	required, err := app.models.Roles.RequiresTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if required {
+		v := validator.New()
+		v.AddError("2fa", "your role requires two-factor authentication")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.DisableTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if !app.readSecondFactor(w, r, user) {
+		return
+	}
+
+	recoveryCodes, err := app.models.Users.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loginTwoFactorHandler is the second step of logging in. It exchanges the
+// challenge token from createAuthTokenHandler and a TOTP or recovery code
+// for an access and refresh token. Wrong codes count as failed logins.
+func (app *application) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ChallengeToken != "", "challenge_token", "must be provided")
+	model.ValidateVerificationCode(v, input.Code)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ip := clientIP(r)
+	if wait := app.loginFailures.wait(ip); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
+	claims, err := app.auth.ValidateChallengeToken(input.ChallengeToken)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByUsername(claims.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The challenge dies with the password, like refresh tokens do.
+	if claims.CustomKey != app.auth.GenerateCustomKey(user.Username, user.TokenHash) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if !app.checkLoginState(w, r, user) {
+		return
+	}
+
+	twoFactor, err := app.models.Users.GetTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !twoFactor.Enabled {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(user.ID, twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.loginFailures.fail(ip)
+		app.recordLoginFailure(w, r, user)
+		return
+	}
+
+	err = app.models.Users.ResetLoginFailures(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.startSession(r, user, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateRoleTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Required *bool `json:"required"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Required != nil, "required", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	name := mux.Vars(r)["role"]
+	err = app.models.Roles.SetRequire2FA(name, *input.Required, app.actor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	roles, err := app.models.Roles.List()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	app.notFoundResponse(w, r)
+}
+
+// readSecondFactor reads a {"code": ...} body and checks it against the
+// user's enabled second factor. Wrong codes count against the client like
+// failed logins do, so that they cannot be guessed one after another. If it
+// returns false a response has already been written.
+func (app *application) readSecondFactor(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+	if model.ValidateVerificationCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	ip := clientIP(r)
+	if wait := app.loginFailures.wait(ip); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return false
+	}
+
+	twoFactor, err := app.models.Users.GetTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !twoFactor.Enabled {
+		v.AddError("2fa", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	ok, err := app.verifySecondFactor(user.ID, twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !ok {
+		app.loginFailures.fail(ip)
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+// verifySecondFactor accepts either a current TOTP code, which can only be
+// used once, or an unused recovery code, which is then used up.
+func (app *application) verifySecondFactor(userID int64, twoFactor *model.TwoFactor, code string) (bool, error) {
+	if step, ok := auth.ValidateTOTP(twoFactor.Secret, code, time.Now()); ok {
+		return app.models.Users.UseTOTPStep(userID, step)
+	}
+	return app.models.Users.UseRecoveryCode(userID, code)
+}
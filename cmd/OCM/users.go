@@ -5,6 +5,20 @@ import (
 	"OCM/pkg/OCM/validator"
 	"errors"
 	"net/http"
+	"time"
+)
+
+const (
+	activationCodeTTL         = 3 * 24 * time.Hour
+	activationResendPeriod    = 2 * time.Minute
+	passwordResetCodeTTL      = 45 * time.Minute
+	passwordResetResendPeriod = 2 * time.Minute
+	emailChangeCodeTTL        = 24 * time.Hour
+
+	// A client may ask for emails to be sent to any address, so it gets a
+	// few at once and then one a minute.
+	emailRequestBurst = 5
+	emailRequestRate  = 1.0 / 60
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,12 +48,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	v := validator.New()
-	if model.ValidateUser(v, user); !v.Valid() {
+	model.ValidateUser(v, user)
+	app.checkPasswordPolicy(v, "password", input.Password, user)
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(user, app.actor(r))
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrDuplicateEmail):
@@ -53,7 +69,23 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	err = app.mailer.Send(user.Email, "user_welcome.tmpl", user)
+
+	verification, err := app.models.Verifications.New(user.ID, activationCodeTTL, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationCode": verification.PlainText,
+			"userID":         user.ID,
+		}
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
 
 	var output struct {
 		Username string `json:"username"`
@@ -67,3 +99,301 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateVerificationCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByVerificationCode(model.ScopeActivation, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired activation code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Users.ActivateUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user.Activated = true
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if wait := app.emailLimiter.wait(clientIP(r)); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The previous code was issued activationCodeTTL before its expiry, so
+	// refuse to send another one until activationResendPeriod has passed.
+	previous, err := app.models.Verifications.GetByUserID(user.ID, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if previous != nil {
+		wait := time.Until(previous.Expiry.Add(-activationCodeTTL).Add(activationResendPeriod))
+		if wait > 0 {
+			app.rateLimitExceededResponse(w, r, wait)
+			return
+		}
+	}
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verification, err := app.models.Verifications.New(user.ID, activationCodeTTL, model.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationCode": verification.PlainText,
+		}
+		err := app.mailer.Send(user.Email, "user_activation.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetCodeHandler emails a password reset code. It answers
+// the same whether or not the address belongs to an activated account, and
+// does not send another code to the same account within
+// passwordResetResendPeriod, so that it tells nothing about the address.
+func (app *application) createPasswordResetCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if wait := app.emailLimiter.wait(clientIP(r)); wait > 0 {
+		app.rateLimitExceededResponse(w, r, wait)
+		return
+	}
+
+	err = app.sendPasswordResetCode(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "if an activated account uses this address, an email will be sent to it containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendPasswordResetCode emails a new password reset code to the activated
+// account with the address, if there is one and it has not been sent a code
+// within passwordResetResendPeriod.
+func (app *application) sendPasswordResetCode(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+	if !user.Activated {
+		return nil
+	}
+
+	// The previous code was issued passwordResetCodeTTL before its expiry.
+	previous, err := app.models.Verifications.GetByUserID(user.ID, model.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+	if previous != nil && time.Until(previous.Expiry.Add(-passwordResetCodeTTL).Add(passwordResetResendPeriod)) > 0 {
+		return nil
+	}
+
+	// Only the most recently requested code stays valid.
+	err = app.models.Verifications.Delete(user.ID, model.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	verification, err := app.models.Verifications.New(user.ID, passwordResetCodeTTL, model.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetCode": verification.PlainText,
+		}
+		err := app.mailer.Send(user.Email, "user_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+	return nil
+}
+
+func (app *application) resetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidatePasswordPlaintext(v, input.Password)
+	model.ValidateVerificationCode(v, input.Code)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByVerificationCode(model.ScopePasswordReset, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired password reset code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.checkPasswordPolicy(v, "password", input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A new token hash invalidates every refresh token issued so far.
+	user.TokenHash = app.auth.GenerateRandomString(15)
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Verifications.Delete(user.ID, model.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
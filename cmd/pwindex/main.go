@@ -0,0 +1,106 @@
+// Command pwindex builds the breached password index the API loads with
+// -password-breached-list. It reads either the SHA-1 lists published by
+// Have I Been Pwned ("HASH:count" per line) or plain passwords, one per
+// line.
+//
+//	pwindex -format sha1 -out breached.idx pwned-passwords-sha1.txt
+package main
+
+import (
+	"OCM/pkg/OCM/password"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	format := flag.String("format", "sha1", "Input format (sha1|plain)")
+	out := flag.String("out", "breached.idx", "Index file to write")
+	minCount := flag.Int("min-count", 1, "With -format sha1, skip hashes seen fewer times than this")
+	flag.Parse()
+
+	if *format != "sha1" && *format != "plain" {
+		log.Fatalf("unknown format %q", *format)
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("no input files given")
+	}
+
+	var hashes [][sha1.Size]byte
+	for _, path := range flag.Args() {
+		err := readFile(path, *format, *minCount, &hashes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	err = password.WriteIndex(w, hashes)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	breached, err := password.LoadBreached(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d hashes to %s", breached.Len(), *out)
+}
+
+func readFile(path, format string, minCount int, hashes *[][sha1.Size]byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		var hash [sha1.Size]byte
+		switch format {
+		case "plain":
+			hash = sha1.Sum([]byte(text))
+		case "sha1":
+			hexHash, countText, _ := strings.Cut(text, ":")
+			if countText != "" {
+				var count int
+				_, err := fmt.Sscan(countText, &count)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid count %q", path, line, countText)
+				}
+				if count < minCount {
+					continue
+				}
+			}
+			if len(hexHash) != 2*sha1.Size {
+				return fmt.Errorf("%s:%d: invalid SHA-1 hash %q", path, line, hexHash)
+			}
+			_, err := hex.Decode(hash[:], []byte(hexHash))
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid SHA-1 hash %q", path, line, hexHash)
+			}
+		}
+		*hashes = append(*hashes, hash)
+	}
+	return scanner.Err()
+}
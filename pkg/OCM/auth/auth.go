@@ -3,76 +3,148 @@ package auth
 import (
 	"OCM/pkg/OCM/model"
 	"crypto/hmac"
+	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// RevocationStore remembers access tokens that were revoked before they
+// expired, keyed by their jti claim.
+type RevocationStore interface {
+	Revoke(jti string, expiry time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// Issuer is the iss claim of every token the service signs. Refresh and
+// challenge tokens are also addressed to it, so they can't be mistaken for
+// access tokens by other services.
+const Issuer = "ocm.auth.service"
+
 type AuthService struct {
-	signKey string
+	keySecret   string
+	audience    string
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+	keys        KeyStore
+	ring        keyring
+	revocations RevocationStore
 }
 
-func NewAuthService(key string) *AuthService {
-	return &AuthService{signKey: key}
+// NewAuthService returns a service that signs tokens with keys from the
+// given store. keySecret encrypts the private keys at rest and audience is
+// the aud claim of access tokens. No tokens can be signed until
+// StartKeyRotation has been called.
+func NewAuthService(keySecret, audience string, accessTTL, refreshTTL time.Duration, keys KeyStore, revocations RevocationStore) *AuthService {
+	return &AuthService{
+		keySecret:   keySecret,
+		audience:    audience,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+		keys:        keys,
+		revocations: revocations,
+	}
 }
 
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// challengeTTL is how long a user has to enter their second factor after
+// giving the right password.
+const challengeTTL = 5 * time.Minute
+
+// Refresh and access tokens belong to a session, identified by SessionID.
+// MFA records that the session was started with a second factor.
 type RefreshTokenCustomClaims struct {
 	Username  string
 	CustomKey string
 	KeyType   string
+	MFA       bool
+	SessionID int64
 	jwt.RegisteredClaims
 }
 
+// AccessTokenCustomClaims carry the user's roles at the time the token was
+// issued, for clients to show. Permissions are always checked against the
+// roles the user has now.
 type AccessTokenCustomClaims struct {
 	UserId    int64
 	Username  string
 	Email     string
 	Activated bool
-	Role      string
+	Roles     []string
+	KeyType   string
+	MFA       bool
+	SessionID int64
+	jwt.RegisteredClaims
+}
+
+// ChallengeTokenCustomClaims is handed out instead of a token pair when the
+// password was right but the user still has to prove their second factor.
+type ChallengeTokenCustomClaims struct {
+	UserId    int64
+	Username  string
+	CustomKey string
 	KeyType   string
 	jwt.RegisteredClaims
 }
 
-func (auth *AuthService) GenerateRefreshToken(user *model.User) (string, error) {
+// GenerateRefreshToken issues the next refresh token of the session and
+// records its ID and expiry on the session, which the caller has to save.
+func (auth *AuthService) GenerateRefreshToken(user *model.User, session *model.Session) (string, error) {
 
 	cusKey := auth.GenerateCustomKey(user.Username, user.TokenHash)
 
+	session.RefreshTokenID = auth.generateTokenID()
+	session.Expiry = time.Now().Add(auth.refreshTTL)
+
 	claims := RefreshTokenCustomClaims{
 		user.Username,
 		cusKey,
 		"refresh",
+		session.MFA,
+		session.ID,
 		jwt.RegisteredClaims{
-			Issuer: "ocm.auth.service",
+			ID:        session.RefreshTokenID,
+			ExpiresAt: jwt.NewNumericDate(session.Expiry),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{Issuer},
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	return token.SignedString([]byte(auth.signKey))
+	return auth.sign(claims)
 }
-func (auth *AuthService) GenerateAccessToken(user *model.User, role string) (string, error) {
+
+// GenerateAccessToken issues an access token for the session and records
+// its ID and expiry on the session, so it can be revoked with it.
+func (auth *AuthService) GenerateAccessToken(user *model.User, roles []string, session *model.Session) (string, error) {
+
+	session.AccessTokenID = auth.generateTokenID()
+	session.AccessExpiry = time.Now().Add(auth.accessTTL)
 
 	claims := AccessTokenCustomClaims{
 		user.ID,
 		user.Username,
 		user.Email,
 		user.Activated,
-		role,
+		roles,
 		"access",
+		session.MFA,
+		session.ID,
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
-			Issuer:    "ocm.auth.",
+			ID:        session.AccessTokenID,
+			ExpiresAt: jwt.NewNumericDate(session.AccessExpiry),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{auth.audience},
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	return token.SignedString([]byte(auth.signKey))
+	return auth.sign(claims)
 }
 
 func (auth *AuthService) GenerateCustomKey(username string, tokenHash string) string {
@@ -85,47 +157,117 @@ func (auth *AuthService) GenerateCustomKey(username string, tokenHash string) st
 
 func (auth *AuthService) ValidateAccessToken(tokenString string) (*AccessTokenCustomClaims, error) {
 
-	token, err := jwt.ParseWithClaims(tokenString, &AccessTokenCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(auth.signKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &AccessTokenCustomClaims{}, auth.keyFunc, auth.parserOptions(auth.audience)...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	claims, ok := token.Claims.(*AccessTokenCustomClaims)
-	if !ok || !token.Valid || claims.Username == "" || claims.UserId == 0 || claims.Email == "" || claims.Role == "" || claims.KeyType != "access" {
+	if !ok || !token.Valid || claims.ID == "" || claims.SessionID == 0 || claims.Username == "" || claims.UserId == 0 || claims.Email == "" || claims.KeyType != "access" {
 		return nil, errors.New("invalid token: authentication failed")
 	}
+
+	revoked, err := auth.revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
 	return claims, nil
 }
 
+// RevokeAccessToken makes the given access token unusable for the rest of
+// its lifetime.
+func (auth *AuthService) RevokeAccessToken(claims *AccessTokenCustomClaims) error {
+	return auth.revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
+
 func (auth *AuthService) ValidateRefreshToken(tokenString string) (*RefreshTokenCustomClaims, error) {
 
-	token, err := jwt.ParseWithClaims(tokenString, &RefreshTokenCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(auth.signKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &RefreshTokenCustomClaims{}, auth.keyFunc, auth.parserOptions(Issuer)...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	claims, ok := token.Claims.(*RefreshTokenCustomClaims)
-	if !ok || !token.Valid || claims.Username == "" || claims.KeyType != "refresh" {
+	if !ok || !token.Valid || claims.ID == "" || claims.SessionID == 0 || claims.Username == "" || claims.KeyType != "refresh" {
 		return nil, errors.New("invalid token: authentication failed")
 	}
 	return claims, nil
 }
 
+func (auth *AuthService) GenerateChallengeToken(user *model.User) (string, error) {
+
+	claims := ChallengeTokenCustomClaims{
+		user.ID,
+		user.Username,
+		auth.GenerateCustomKey(user.Username, user.TokenHash),
+		"2fa-challenge",
+		jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{Issuer},
+		},
+	}
+
+	return auth.sign(claims)
+}
+
+func (auth *AuthService) ValidateChallengeToken(tokenString string) (*ChallengeTokenCustomClaims, error) {
+
+	token, err := jwt.ParseWithClaims(tokenString, &ChallengeTokenCustomClaims{}, auth.keyFunc, auth.parserOptions(Issuer)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*ChallengeTokenCustomClaims)
+	if !ok || !token.Valid || claims.Username == "" || claims.UserId == 0 || claims.KeyType != "2fa-challenge" {
+		return nil, errors.New("invalid token: authentication failed")
+	}
+	return claims, nil
+}
+
+func (auth *AuthService) parserOptions(audience string) []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	}
+}
+
+func (auth *AuthService) generateTokenID() string {
+	b := make([]byte, 16)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-// GenerateRandomString generate a string of random characters of given length
+// GenerateRandomString generate a string of random characters of given length.
+// The result is used as a user's token hash, so it comes from crypto/rand.
 func (auth *AuthService) GenerateRandomString(n int) string {
+	// Bytes from maxByte up are skipped so that every letter is equally
+	// likely.
+	const maxByte = 256 - 256%len(letterBytes)
+
 	sb := strings.Builder{}
 	sb.Grow(n)
-	for i := 0; i < n; i++ {
-		idx := rand.Int63() % int64(len(letterBytes))
-		sb.WriteByte(letterBytes[idx])
+	b := make([]byte, n)
+	for sb.Len() < n {
+		if _, err := crand.Read(b); err != nil {
+			panic(err)
+		}
+		for _, c := range b {
+			if int(c) < maxByte && sb.Len() < n {
+				sb.WriteByte(letterBytes[int(c)%len(letterBytes)])
+			}
+		}
 	}
 	return sb.String()
 }
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRandomString(t *testing.T) {
+	var auth AuthService
+
+	for _, n := range []int{0, 1, 15, 100} {
+		s := auth.GenerateRandomString(n)
+		if len(s) != n {
+			t.Errorf("GenerateRandomString(%d) has length %d", n, len(s))
+		}
+		if strings.Trim(s, letterBytes) != "" {
+			t.Errorf("GenerateRandomString(%d) = %q, want only letters", n, s)
+		}
+	}
+
+	if auth.GenerateRandomString(15) == auth.GenerateRandomString(15) {
+		t.Error("GenerateRandomString(15) returned the same string twice")
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	auth := AuthService{keySecret: "secret"}
+
+	sealed, err := auth.SealSecret([]byte("JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "JBSWY3DPEHPK3PXP") {
+		t.Error("sealed secret contains the plaintext")
+	}
+
+	opened, err := auth.OpenSecret(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("OpenSecret = %q, want %q", opened, "JBSWY3DPEHPK3PXP")
+	}
+
+	other := AuthService{keySecret: "other"}
+	if _, err := other.OpenSecret(sealed); err == nil {
+		t.Error("OpenSecret with another key secret succeeded")
+	}
+	if _, err := auth.OpenSecret(sealed[:4]); err == nil {
+		t.Error("OpenSecret of a truncated secret succeeded")
+	}
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"OCM/pkg/OCM/model"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyStore persists signing keys so that every instance of the service
+// signs with the same key and can verify tokens signed by the others.
+type KeyStore interface {
+	List() ([]*model.SigningKey, error)
+	Rotate(key *model.SigningKey, retireAt, retiredExpiry time.Time, minAge time.Duration) (bool, error)
+}
+
+// JWKSMaxAge is how long other services may cache the published keys. A new
+// key is published this long before it signs anything, and the key it
+// replaces keeps signing until then.
+const JWKSMaxAge = 5 * time.Minute
+
+// reloadInterval limits how often we go back to the store for keys another
+// instance might have created or retired.
+const reloadInterval = time.Minute
+
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// keyring holds the active private key and the public keys of every key
+// that can still verify tokens. The active key must not be used after
+// refreshAt without loading the keys again.
+type keyring struct {
+	mu        sync.RWMutex
+	activeID  string
+	active    ed25519.PrivateKey
+	public    map[string]ed25519.PublicKey
+	loadedAt  time.Time
+	refreshAt time.Time
+}
+
+// StartKeyRotation makes sure there is an active signing key, then keeps
+// replacing it with a new one every interval. A new key only takes over once
+// it has been published for JWKSMaxAge. Retired keys keep verifying tokens
+// until the longest-lived token they could have signed has expired.
+func (auth *AuthService) StartKeyRotation(interval time.Duration, logger *log.Logger) error {
+	_, err := auth.rotateKeys(interval)
+	if err != nil {
+		return err
+	}
+	err = auth.loadKeys()
+	if err != nil {
+		return err
+	}
+
+	check := interval / 10
+	if check > time.Hour {
+		check = time.Hour
+	}
+	go func() {
+		for {
+			time.Sleep(check)
+			rotated, err := auth.rotateKeys(interval)
+			if err != nil {
+				logger.Printf("signing key rotation failed: %v", err)
+				continue
+			}
+			if rotated {
+				logger.Printf("rotated token signing key")
+			}
+			err = auth.loadKeys()
+			if err != nil {
+				logger.Printf("loading signing keys failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// rotateKeys creates a new signing key unless the active one is younger
+// than minAge.
+func (auth *AuthService) rotateKeys(minAge time.Duration) (bool, error) {
+	public, private, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		return false, err
+	}
+	sealed, err := auth.sealKey(private)
+	if err != nil {
+		return false, err
+	}
+
+	kid := make([]byte, 8)
+	_, err = crand.Read(kid)
+	if err != nil {
+		return false, err
+	}
+
+	key := &model.SigningKey{
+		ID:         hex.EncodeToString(kid),
+		PrivateKey: sealed,
+		PublicKey:  public,
+	}
+
+	lifetime := auth.accessTTL
+	if auth.refreshTTL > lifetime {
+		lifetime = auth.refreshTTL
+	}
+	retireAt := time.Now().Add(JWKSMaxAge)
+	return auth.keys.Rotate(key, retireAt, retireAt.Add(lifetime), minAge)
+}
+
+// loadKeys reads the keys from the store. The active key is the newest one
+// that has been published for JWKSMaxAge and isn't retired yet.
+func (auth *AuthService) loadKeys() error {
+	keys, err := auth.keys.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	refreshAt := now.Add(reloadInterval)
+	public := make(map[string]ed25519.PublicKey, len(keys))
+	var newest, active *model.SigningKey
+	for _, key := range keys {
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return errors.New("malformed signing key " + key.ID)
+		}
+		public[key.ID] = ed25519.PublicKey(key.PublicKey)
+
+		// Keys are listed newest first.
+		if active != nil || (key.RetiredAt != nil && !key.RetiredAt.After(now)) {
+			continue
+		}
+		if newest == nil {
+			newest = key
+		}
+		if published := key.CreatedAt.Add(JWKSMaxAge); published.After(now) {
+			// Other services may not have fetched this key yet.
+			if published.Before(refreshAt) {
+				refreshAt = published
+			}
+			continue
+		}
+		active = key
+	}
+	if active == nil {
+		// On the first start no key has been published for long enough,
+		// and no token has been signed yet either.
+		active = newest
+		refreshAt = now.Add(reloadInterval)
+	}
+	if active == nil {
+		return errors.New("no active signing key")
+	}
+	if active.RetiredAt != nil && active.RetiredAt.Before(refreshAt) {
+		refreshAt = *active.RetiredAt
+	}
+
+	private, err := auth.openKey(active.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	auth.ring.mu.Lock()
+	defer auth.ring.mu.Unlock()
+	auth.ring.activeID = active.ID
+	auth.ring.active = private
+	auth.ring.public = public
+	auth.ring.loadedAt = now
+	auth.ring.refreshAt = refreshAt
+	return nil
+}
+
+// sign signs claims with the active key and names the key in the kid header.
+func (auth *AuthService) sign(claims jwt.Claims) (string, error) {
+	auth.ring.mu.RLock()
+	kid, key, refreshAt := auth.ring.activeID, auth.ring.active, auth.ring.refreshAt
+	auth.ring.mu.RUnlock()
+
+	if time.Now().After(refreshAt) {
+		// Another instance may have retired the key, or a newer one may be
+		// due to take over.
+		err := auth.loadKeys()
+		if err != nil {
+			return "", err
+		}
+		auth.ring.mu.RLock()
+		kid, key = auth.ring.activeID, auth.ring.active
+		auth.ring.mu.RUnlock()
+	}
+
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// keyFunc finds the public key a token was signed with.
+func (auth *AuthService) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	auth.ring.mu.RLock()
+	key, found := auth.ring.public[kid]
+	stale := time.Since(auth.ring.loadedAt) > reloadInterval
+	auth.ring.mu.RUnlock()
+
+	if !found && stale {
+		// Another instance may have rotated since we last looked.
+		err := auth.loadKeys()
+		if err != nil {
+			return nil, err
+		}
+		auth.ring.mu.RLock()
+		key, found = auth.ring.public[kid]
+		auth.ring.mu.RUnlock()
+	}
+	if !found {
+		return nil, errUnknownKey
+	}
+	return key, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517, RFC 8037).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKS returns the public keys that tokens may currently be signed with.
+func (auth *AuthService) JWKS() []JWK {
+	auth.ring.mu.RLock()
+	defer auth.ring.mu.RUnlock()
+
+	keys := make([]JWK, 0, len(auth.ring.public))
+	for kid, key := range auth.ring.public {
+		keys = append(keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key),
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+		})
+	}
+	return keys
+}
+
+// sealKey encrypts a private key with AES-GCM before it goes to the store.
+func (auth *AuthService) sealKey(key ed25519.PrivateKey) ([]byte, error) {
+	return auth.SealSecret(key.Seed())
+}
+
+func (auth *AuthService) openKey(sealed []byte) (ed25519.PrivateKey, error) {
+	seed, err := auth.OpenSecret(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("malformed signing key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// SealSecret encrypts a secret with AES-GCM under the key secret, with the
+// nonce in front. It is used for everything the service has to store but be
+// able to read back, such as signing keys and TOTP secrets.
+func (auth *AuthService) SealSecret(plaintext []byte) ([]byte, error) {
+	gcm, err := auth.keyCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = crand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// OpenSecret decrypts a secret sealed by SealSecret.
+func (auth *AuthService) OpenSecret(sealed []byte) ([]byte, error) {
+	gcm, err := auth.keyCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed sealed secret")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt secret, was the key secret changed?")
+	}
+	return plaintext, nil
+}
+
+func (auth *AuthService) keyCipher() (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(auth.keySecret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
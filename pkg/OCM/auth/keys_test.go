@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"OCM/pkg/OCM/model"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type memoryKeyStore struct {
+	keys []*model.SigningKey
+}
+
+func (s *memoryKeyStore) List() ([]*model.SigningKey, error) {
+	return s.keys, nil
+}
+
+func (s *memoryKeyStore) Rotate(key *model.SigningKey, retireAt, retiredExpiry time.Time, minAge time.Duration) (bool, error) {
+	return false, nil
+}
+
+func (s *memoryKeyStore) add(t *testing.T, auth *AuthService, id string, createdAt time.Time, retiredAt *time.Time) {
+	public, private, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := auth.sealKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first, like SigningKeyModel.List.
+	s.keys = append([]*model.SigningKey{{
+		ID:         id,
+		PrivateKey: sealed,
+		PublicKey:  public,
+		CreatedAt:  createdAt,
+		RetiredAt:  retiredAt,
+	}}, s.keys...)
+}
+
+func signedKid(t *testing.T, auth *AuthService) string {
+	signed, err := auth.sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestLoadKeysWaitsForPublication(t *testing.T) {
+	store := &memoryKeyStore{}
+	auth := &AuthService{keySecret: "secret", keys: store}
+
+	now := time.Now()
+	retireAt := now.Add(JWKSMaxAge)
+	store.add(t, auth, "old", now.Add(-time.Hour), &retireAt)
+	store.add(t, auth, "new", now, nil)
+
+	if err := auth.loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKid(t, auth); kid != "old" {
+		t.Errorf("signed with %q before the new key was published, want %q", kid, "old")
+	}
+	if len(auth.JWKS()) != 2 {
+		t.Errorf("JWKS has %d keys, want 2", len(auth.JWKS()))
+	}
+}
+
+func TestLoadKeysFirstStart(t *testing.T) {
+	store := &memoryKeyStore{}
+	auth := &AuthService{keySecret: "secret", keys: store}
+	store.add(t, auth, "first", time.Now(), nil)
+
+	if err := auth.loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signedKid(t, auth); kid != "first" {
+		t.Errorf("signed with %q, want %q", kid, "first")
+	}
+}
+
+func TestSignReloadsRetiredKey(t *testing.T) {
+	store := &memoryKeyStore{}
+	auth := &AuthService{keySecret: "secret", keys: store}
+
+	now := time.Now()
+	store.add(t, auth, "old", now.Add(-time.Hour), nil)
+	if err := auth.loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance rotated and the old key has been retired since.
+	retiredAt := now.Add(-time.Second)
+	store.keys[0].RetiredAt = &retiredAt
+	store.add(t, auth, "new", now.Add(-JWKSMaxAge-time.Second), nil)
+	auth.ring.refreshAt = retiredAt
+
+	if kid := signedKid(t, auth); kid != "new" {
+		t.Errorf("signed with %q after it was retired, want %q", kid, "new")
+	}
+}
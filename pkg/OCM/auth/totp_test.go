@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the SHA-1 test vectors of RFC 6238, appendix B,
+// truncated to 6 digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for _, tt := range rfc6238Vectors {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s) at %d = %d, %v, want %d, true", tt.code, tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+
+	// 1111111111 is step 37037037, whose code is 050471.
+	const code = "050471"
+	const step = 37037037
+	at := func(steps int64) time.Time {
+		return time.Unix((step+steps)*totpPeriod, 0)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		t      time.Time
+		ok     bool
+	}{
+		{"current step", rfc6238Secret, code, at(0), true},
+		{"one step early", rfc6238Secret, code, at(1), true},
+		{"one step late", rfc6238Secret, code, at(-1), true},
+		{"two steps early", rfc6238Secret, code, at(2), false},
+		{"two steps late", rfc6238Secret, code, at(-2), false},
+		{"surrounding spaces", rfc6238Secret, " " + code + " ", at(0), true},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), code, at(0), true},
+		{"wrong code", rfc6238Secret, "050472", at(0), false},
+		{"too short", rfc6238Secret, code[:5], at(0), false},
+		{"too long", rfc6238Secret, code + "0", at(0), false},
+		{"empty code", rfc6238Secret, "", at(0), false},
+		{"invalid secret", "not base32!", code, at(0), false},
+		{"other secret", "JBSWY3DPEHPK3PXP", code, at(0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.secret, tt.code, tt.t)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP(%q, %q) ok = %v, want %v", tt.secret, tt.code, ok, tt.ok)
+			}
+			if ok && got != step {
+				t.Errorf("ValidateTOTP(%q, %q) step = %d, want %d", tt.secret, tt.code, got, step)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if _, ok := ValidateTOTP(secret, code, time.Now()); !ok {
+		t.Error("a code from a generated secret was rejected")
+	}
+}
@@ -90,3 +90,448 @@ CREATE TABLE IF NOT EXISTS verifications
 )
   on delete CASCADE
     );
+
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti    text PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+ALTER TABLE verifications
+    ADD COLUMN IF NOT EXISTS scope text NOT NULL DEFAULT 'activation';
+
+ALTER TABLE bans
+    ADD COLUMN IF NOT EXISTS reason     text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS banned_by  bigint REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id   bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id       bigint NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name)
+VALUES ('admin'),
+       ('user')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (code)
+VALUES ('courses:read'),
+       ('courses:write'),
+       ('courses:delete'),
+       ('assignments:read'),
+       ('assignments:write'),
+       ('students:read'),
+       ('students:write'),
+       ('users:read'),
+       ('users:write'),
+       ('roles:write'),
+       ('bans:write')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+   OR (roles.name = 'user' AND permissions.code IN ('courses:read', 'assignments:read'))
+ON CONFLICT DO NOTHING;
+
+-- Existing accounts: everyone gets the user role, members of the old admins
+-- table get the admin role as well.
+INSERT INTO user_roles (user_id, role_id)
+SELECT users.id, roles.id
+FROM users,
+     roles
+WHERE roles.name = 'user'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT admins.user_id, roles.id
+FROM admins,
+     roles
+WHERE roles.name = 'admin'
+  AND admins.user_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS admins;
+
+CREATE TABLE IF NOT EXISTS course
+(
+    courseid       serial PRIMARY KEY,
+    title          varchar(255) NOT NULL,
+    description    text,
+    courseduration varchar(50)
+);
+
+CREATE TABLE IF NOT EXISTS course_instructors
+(
+    course_id integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    user_id   bigint  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (course_id, user_id)
+);
+
+INSERT INTO roles (name)
+VALUES ('instructor')
+ON CONFLICT (name) DO NOTHING;
+
+-- courses:manage lets a user edit any course, not only the ones they teach.
+INSERT INTO permissions (code)
+VALUES ('courses:manage')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE (roles.name = 'admin' AND permissions.code = 'courses:manage')
+   OR (roles.name = 'instructor' AND permissions.code IN ('courses:read', 'courses:write', 'assignments:read', 'assignments:write'))
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS failed_logins     integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_failed_login timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS locked_until      timestamp(0) with time zone;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret    text,
+    ADD COLUMN IF NOT EXISTS totp_enabled   boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint  NOT NULL DEFAULT 0;
+
+-- TOTP secrets are sealed with AES-GCM under the key secret, like signing
+-- keys. totp_secret held them in plaintext; the application seals whatever
+-- is left there into totp_secret_sealed when it starts.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret_sealed bytea;
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id      bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code    bytea  NOT NULL,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+ALTER TABLE roles
+    ADD COLUMN IF NOT EXISTS require_2fa boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    kid         text PRIMARY KEY,
+    private_key bytea                       NOT NULL,
+    public_key  bytea                       NOT NULL,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT now(),
+    retired_at  timestamp(0) with time zone,
+    expiry      timestamp(0) with time zone
+);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           bigserial PRIMARY KEY,
+    user_id      bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         text                        NOT NULL,
+    prefix       text                        NOT NULL,
+    hash         bytea                       NOT NULL UNIQUE,
+    scopes       text[]                      NOT NULL,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT now(),
+    expiry       timestamp(0) with time zone NOT NULL,
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- mfa records whether the key was created by a session that used a second
+-- factor. Roles that require 2FA don't work through keys created without.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS mfa boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    issuer     text                        NOT NULL,
+    subject    text                        NOT NULL,
+    user_id    bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS oidc_logins
+(
+    state         bytea PRIMARY KEY,
+    code_verifier text                        NOT NULL,
+    nonce         text                        NOT NULL,
+    expiry        timestamp(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id               bigserial PRIMARY KEY,
+    user_id          bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent       text                        NOT NULL DEFAULT '',
+    ip               text                        NOT NULL DEFAULT '',
+    mfa              boolean                     NOT NULL DEFAULT false,
+    created_at       timestamp(0) with time zone NOT NULL DEFAULT now(),
+    last_used_at     timestamp(0) with time zone NOT NULL DEFAULT now(),
+    expiry           timestamp(0) with time zone NOT NULL,
+    refresh_token_id text                        NOT NULL DEFAULT '',
+    access_token_id  text                        NOT NULL DEFAULT '',
+    access_expiry    timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+ALTER TABLE verifications
+    ADD COLUMN IF NOT EXISTS payload text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS student
+(
+    studentid serial PRIMARY KEY,
+    name      varchar(50) NOT NULL,
+    age       integer     NOT NULL,
+    gpa       float
+);
+
+CREATE TABLE IF NOT EXISTS student_course
+(
+    studentid integer NOT NULL REFERENCES student (studentid) ON DELETE CASCADE,
+    courseid  integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    PRIMARY KEY (studentid, courseid)
+);
+
+-- A user can be linked to at most one student record and the other way round.
+ALTER TABLE student
+    ADD COLUMN IF NOT EXISTS user_id bigint UNIQUE REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS student_invitations
+(
+    code       bytea PRIMARY KEY,
+    student_id integer                     NOT NULL REFERENCES student (studentid) ON DELETE CASCADE,
+    email      text                        NOT NULL,
+    expiry     timestamp(0) with time zone NOT NULL
+);
+
+-- The audit log is append-only: rows are never updated or deleted, which is
+-- also why actor_id has no foreign key that could null it out.
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id            bigserial PRIMARY KEY,
+    actor_id      bigint,
+    action        text                        NOT NULL,
+    resource_type text                        NOT NULL,
+    resource_id   text                        NOT NULL,
+    before        jsonb,
+    after         jsonb,
+    ip            text                        NOT NULL DEFAULT '',
+    request_id    text                        NOT NULL DEFAULT '',
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log (resource_type, resource_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (code)
+VALUES ('audit:read')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'audit:read'
+ON CONFLICT DO NOTHING;
+
+-- Positions are unique within the parent, but only checked at commit, so
+-- that reordering can swap rows within a transaction.
+CREATE TABLE IF NOT EXISTS course_modules
+(
+    id        bigserial PRIMARY KEY,
+    course_id integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    title     text    NOT NULL,
+    position  integer NOT NULL,
+    CONSTRAINT course_modules_position_key UNIQUE (course_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE TABLE IF NOT EXISTS lessons
+(
+    id        bigserial PRIMARY KEY,
+    module_id bigint  NOT NULL REFERENCES course_modules (id) ON DELETE CASCADE,
+    title     text    NOT NULL,
+    body      text    NOT NULL DEFAULT '',
+    position  integer NOT NULL,
+    CONSTRAINT lessons_position_key UNIQUE (module_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- The primary key of student_course already keeps a student from being
+-- enrolled in a course twice; dropped enrollments are kept as history.
+ALTER TABLE student_course
+    ADD COLUMN IF NOT EXISTS status      text                        NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS enrolled_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at  timestamp(0) with time zone NOT NULL DEFAULT now();
+
+ALTER TABLE student_course
+    DROP CONSTRAINT IF EXISTS student_course_status_check;
+ALTER TABLE student_course
+    ADD CONSTRAINT student_course_status_check CHECK (status IN ('active', 'waitlisted', 'dropped', 'completed'));
+
+CREATE INDEX IF NOT EXISTS student_course_courseid_idx ON student_course (courseid);
+
+-- NULL capacity means the course takes any number of students. Waitlisted
+-- enrollments are ordered by waitlist_seq, which is NULL for all others.
+ALTER TABLE course
+    ADD COLUMN IF NOT EXISTS capacity integer CHECK (capacity >= 0);
+
+CREATE SEQUENCE IF NOT EXISTS student_course_waitlist_seq;
+
+ALTER TABLE student_course
+    ADD COLUMN IF NOT EXISTS waitlist_seq bigint;
+
+CREATE INDEX IF NOT EXISTS student_course_waitlist_idx ON student_course (courseid, waitlist_seq)
+    WHERE status = 'waitlisted';
+
+ALTER TABLE course
+    ADD COLUMN IF NOT EXISTS start_date     date,
+    ADD COLUMN IF NOT EXISTS end_date       date,
+    ADD COLUMN IF NOT EXISTS weekly_hours   numeric(4, 1) CHECK (weekly_hours > 0 AND weekly_hours <= 168),
+    ADD COLUMN IF NOT EXISTS duration_weeks integer;
+
+ALTER TABLE course
+    DROP CONSTRAINT IF EXISTS course_dates_check;
+ALTER TABLE course
+    ADD CONSTRAINT course_dates_check CHECK (end_date >= start_date);
+
+CREATE INDEX IF NOT EXISTS course_start_date_idx ON course (start_date);
+
+-- Courses from before there were dates get their duration from the old
+-- free-text field where it reads like "3 month" or "10 weeks". A month is
+-- counted as 52/12 weeks, rounded. Anything else is left NULL.
+UPDATE course
+SET duration_weeks = CASE
+    WHEN parsed.m[2] = 'day' THEN ceil(parsed.m[1]::numeric / 7)
+    WHEN parsed.m[2] = 'week' THEN round(parsed.m[1]::numeric)
+    WHEN parsed.m[2] = 'month' THEN round(parsed.m[1]::numeric * 52 / 12)
+    WHEN parsed.m[2] = 'year' THEN round(parsed.m[1]::numeric * 52)
+    END
+FROM (SELECT courseid,
+             regexp_match(lower(courseduration), '^\s*(\d+(?:\.\d+)?)\s*(day|week|month|year)s?\s*$') AS m
+      FROM course) parsed
+WHERE course.courseid = parsed.courseid
+  AND course.duration_weeks IS NULL
+  AND course.start_date IS NULL
+  AND parsed.m IS NOT NULL;
+
+-- course_id cannot be taken before prerequisite_id is completed. The
+-- application keeps the graph free of cycles.
+CREATE TABLE IF NOT EXISTS course_prerequisites
+(
+    course_id       integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    prerequisite_id integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    PRIMARY KEY (course_id, prerequisite_id),
+    CHECK (course_id <> prerequisite_id)
+);
+
+CREATE INDEX IF NOT EXISTS course_prerequisites_prerequisite_id_idx ON course_prerequisites (prerequisite_id);
+
+-- The Programming Principles chain, where those courses exist.
+INSERT INTO course_prerequisites (course_id, prerequisite_id)
+SELECT c.courseid, p.courseid
+FROM (VALUES ('Programming Principles II', 'Programming Principles I'),
+             ('Algorithms and Data Structures', 'Programming Principles II')) AS chain (course, prerequisite)
+JOIN course c ON c.title = chain.course
+JOIN course p ON p.title = chain.prerequisite
+ON CONFLICT DO NOTHING;
+
+-- enrollments:override lets a user enroll students who have not completed
+-- the prerequisites of a course.
+INSERT INTO permissions (code)
+VALUES ('enrollments:override')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'enrollments:override'
+ON CONFLICT DO NOTHING;
+
+-- A category with subcategories cannot be deleted until they are moved or
+-- deleted. The application keeps the tree free of cycles.
+CREATE TABLE IF NOT EXISTS categories
+(
+    id        bigserial PRIMARY KEY,
+    name      varchar(100) NOT NULL,
+    parent_id bigint REFERENCES categories (id) ON DELETE RESTRICT
+);
+
+-- Names are unique among siblings, whatever their case.
+CREATE UNIQUE INDEX IF NOT EXISTS categories_parent_name_idx ON categories (COALESCE(parent_id, 0), lower(name));
+
+CREATE TABLE IF NOT EXISTS course_categories
+(
+    course_id   integer NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    category_id bigint  NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    PRIMARY KEY (course_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS course_categories_category_id_idx ON course_categories (category_id);
+
+-- Tags are stored lower-case.
+CREATE TABLE IF NOT EXISTS course_tags
+(
+    course_id integer     NOT NULL REFERENCES course (courseid) ON DELETE CASCADE,
+    tag       varchar(50) NOT NULL,
+    PRIMARY KEY (course_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS course_tags_tag_idx ON course_tags (tag);
+
+-- categories:write lets a user edit the category tree of the catalog.
+INSERT INTO permissions (code)
+VALUES ('categories:write')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'categories:write'
+ON CONFLICT DO NOTHING;
@@ -1,5 +1,51 @@
-DROP TABLE courses;
-DROP TABLE students;
-DROP TABLE verifications;
-DROP TABLE bans;
-DROP TABLE admins;
+DROP TABLE IF EXISTS course_tags;
+DROP TABLE IF EXISTS course_categories;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS course_prerequisites;
+DROP TABLE IF EXISTS lessons;
+DROP TABLE IF EXISTS course_modules;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS student_invitations;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS oidc_logins;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS courses;
+DROP TABLE IF EXISTS students;
+DROP TABLE IF EXISTS verifications;
+DROP TABLE IF EXISTS bans;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS course_instructors;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+DROP SEQUENCE IF EXISTS student_course_waitlist_seq;
+
+-- users, student, student_course and course are kept, so the columns and
+-- indexes added to them are dropped one by one. Their data is lost.
+ALTER TABLE IF EXISTS users
+    DROP COLUMN IF EXISTS failed_logins,
+    DROP COLUMN IF EXISTS last_failed_login,
+    DROP COLUMN IF EXISTS locked_until,
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS totp_secret_sealed,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE IF EXISTS student
+    DROP COLUMN IF EXISTS user_id;
+DROP INDEX IF EXISTS student_course_courseid_idx;
+ALTER TABLE IF EXISTS student_course
+    DROP COLUMN IF EXISTS waitlist_seq,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS enrolled_at,
+    DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE IF EXISTS course
+    DROP COLUMN IF EXISTS duration_weeks,
+    DROP COLUMN IF EXISTS weekly_hours,
+    DROP COLUMN IF EXISTS end_date,
+    DROP COLUMN IF EXISTS start_date,
+    DROP COLUMN IF EXISTS capacity;
@@ -1,6 +1,15 @@
 package model
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultRole is granted to every newly registered user.
+const DefaultRole = "user"
 
 type RoleModel struct {
 	DB *sql.DB
@@ -8,6 +17,13 @@ type RoleModel struct {
 
 type Roles []string
 
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	Require2FA  bool        `json:"require_2fa"`
+}
+
 func (p Roles) Include(role string) bool {
 	if p == nil {
 		return true
@@ -20,11 +36,164 @@ func (p Roles) Include(role string) bool {
 	return false
 }
 
-func (db *RoleModel) GetUserRole(userID int) (string, error) {
-	var role string
-	err := db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+func (db *RoleModel) GetUserRoles(userID int64) (Roles, error) {
+	query := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN user_roles ON user_roles.role_id = roles.id
+	WHERE user_roles.user_id = $1
+	ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := Roles{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (db *RoleModel) List() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name, roles.require_2fa, coalesce(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = role_permissions.permission_id
+	GROUP BY roles.id
+	ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Require2FA, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AddForUser grants the named role to the user. Granting a role the user
+// already has is not an error; an unknown role is ErrRecordNotFound.
+func (db *RoleModel) AddForUser(userID int64, role string, actor Actor) error {
+	exists, err := db.exists(role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = grantRole(ctx, tx, userID, role, actor)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// grantRole grants the named role to the user and writes the grant to the
+// audit log. It reports whether the user did not have the role yet; an
+// unknown role is not granted either.
+func grantRole(ctx context.Context, tx *sql.Tx, userID int64, role string, actor Actor) (bool, error) {
+	query := `
+	INSERT INTO user_roles (user_id, role_id)
+	SELECT $1, roles.id FROM roles WHERE roles.name = $2
+	ON CONFLICT DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	err = writeAudit(ctx, tx, actor, AuditRoleGrant, "user", userID, nil, map[string]string{"role": role})
+	return err == nil, err
+}
+
+func (db *RoleModel) RemoveFromUser(userID int64, role string, actor Actor) error {
+	query := `
+	DELETE FROM user_roles
+	USING roles
+	WHERE user_roles.role_id = roles.id
+	AND user_roles.user_id = $1
+	AND roles.name = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return "", err
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
-	return role, nil
+
+	err = writeAudit(ctx, tx, actor, AuditRoleRevoke, "user", userID, map[string]string{"role": role}, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *RoleModel) exists(role string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := db.DB.QueryRowContext(ctx, query, role).Scan(&exists)
+	return exists, err
 }
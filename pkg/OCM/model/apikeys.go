@@ -0,0 +1,243 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, which is how the authenticate
+// middleware tells them apart from JWTs.
+const APIKeyPrefix = "ocm_"
+
+var scopeRX = regexp.MustCompile(`^([a-z]+|\*):([a-z]+|\*)$`)
+
+// Scopes limit what an API key can do. Each scope is a permission code like
+// "courses:read", where either half may be "*": "courses:*" allows all
+// course permissions and "*:read" makes a read-only key.
+type Scopes []string
+
+// Allow reports whether any of the scopes covers the permission.
+func (s Scopes) Allow(permission string) bool {
+	resource, action, _ := strings.Cut(permission, ":")
+	for _, scope := range s {
+		r, a, _ := strings.Cut(scope, ":")
+		if (r == "*" || r == resource) && (a == "*" || a == action) {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     Scopes     `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	PlainText  string     `json:"key,omitempty"`
+	Hash       []byte     `json:"-"`
+	// MFA is set if the key was created or last rotated by a session that
+	// was started with a second factor.
+	MFA bool `json:"-"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	v.Check(len(key.Scopes) <= 20, "scopes", "must not contain more than 20 scopes")
+	for _, scope := range key.Scopes {
+		v.Check(scopeRX.MatchString(scope), "scopes", "must look like resource:action, e.g. courses:read or *:read")
+	}
+	v.Check(key.Expiry.After(time.Now()), "expires_in_days", "must be greater than zero")
+	v.Check(key.Expiry.Before(time.Now().AddDate(1, 0, 1)), "expires_in_days", "must be a maximum of 365")
+}
+
+// generateAPIKey fills in a new random key. Like verification codes, only
+// the SHA-256 hash is stored.
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	key.PlainText = APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	key.Prefix = key.PlainText[:len(APIKeyPrefix)+6]
+
+	hash := sha256.Sum256([]byte(key.PlainText))
+	key.Hash = hash[:]
+	return nil
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry, mfa)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.Expiry, key.MFA}
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, scopes, created_at, expiry, last_used_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &key.Expiry, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Authenticate looks up an unexpired key and the user it belongs to, and
+// records that the key was used.
+func (m APIKeyModel) Authenticate(plaintext string) (*APIKey, *User, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+	UPDATE api_keys
+	SET last_used_at = now()
+	FROM users
+	WHERE users.id = api_keys.user_id
+	AND api_keys.hash = $1
+	AND api_keys.expiry > now()
+	RETURNING api_keys.id, api_keys.user_id, api_keys.name, api_keys.prefix, api_keys.scopes,
+		api_keys.created_at, api_keys.expiry, api_keys.last_used_at, api_keys.mfa,
+		users.id, users.username, users.email, users.activated`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Scopes),
+		&key.CreatedAt, &key.Expiry, &key.LastUsedAt, &key.MFA,
+		&user.ID, &user.Username, &user.Email, &user.Activated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &key, &user, nil
+}
+
+// Rotate replaces the secret of one of the user's keys. The new key is
+// valid for as long as the old one was when it was created. An expired key
+// can't be rotated back to life and is reported as ErrRecordNotFound. mfa
+// replaces the key's MFA flag.
+func (m APIKeyModel) Rotate(userID, id int64, mfa bool) (*APIKey, error) {
+	key := APIKey{MFA: mfa}
+	err := generateAPIKey(&key)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE api_keys
+	SET hash = $1, prefix = $2, expiry = now() + (expiry - created_at), created_at = now(), last_used_at = NULL, mfa = $5
+	WHERE id = $3 AND user_id = $4 AND expiry > now()
+	RETURNING id, user_id, name, scopes, created_at, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, key.Hash, key.Prefix, id, userID, mfa).Scan(
+		&key.ID, &key.UserID, &key.Name, pq.Array(&key.Scopes), &key.CreatedAt, &key.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+func (m APIKeyModel) Delete(userID, id int64) error {
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser revokes every key of the user, for when whoever holds
+// them may not be the user anymore.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+	DELETE FROM api_keys
+	WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -78,33 +78,67 @@ func (am *AssignmentModel) AllAssignments() ([]*Assignment, error) {
 	return assignments, nil // Return the slice of courses
 }
 
-func (am *AssignmentModel) InsertAssignment(assignment *Assignment) error {
+func (am *AssignmentModel) InsertAssignment(assignment *Assignment, userID int64) error {
 	query := `
 		INSERT INTO assignmentmodel (title, description, courseid) 
 		VALUES ($1, $2, $3) 
-		RETURNING courseid
+		RETURNING id
 		`
 	args := []interface{}{assignment.Title, assignment.Description, assignment.CourseId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return am.DB.QueryRowContext(ctx, query, args...).Scan(&assignment.CourseId)
+	err := authorizeCourse(ctx, am.DB, assignment.CourseId, userID)
+	if err != nil {
+		return err
+	}
+
+	return am.DB.QueryRowContext(ctx, query, args...).Scan(&assignment.AssignmentId)
 }
 
-func (am *AssignmentModel) Update(assignment *Assignment) error {
+// Update saves the assignment. The user must be allowed to modify both the
+// course the assignment currently belongs to and the one it is moved to.
+func (am *AssignmentModel) Update(assignment *Assignment, userID int64) error {
 	query := `
         UPDATE assignmentmodel
-        SET title = $1, description = $2, id = $3
+        SET title = $1, description = $2, courseid = $3
         WHERE id = $4
         RETURNING id
         `
-	args := []interface{}{assignment.Title, assignment.Description, assignment.AssignmentId, assignment.CourseId}
+	args := []interface{}{assignment.Title, assignment.Description, assignment.CourseId, assignment.AssignmentId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	currentCourseID, err := am.courseOf(ctx, assignment.AssignmentId)
+	if err != nil {
+		return err
+	}
+	err = authorizeCourse(ctx, am.DB, currentCourseID, userID)
+	if err != nil {
+		return err
+	}
+	if assignment.CourseId != currentCourseID {
+		err = authorizeCourse(ctx, am.DB, assignment.CourseId, userID)
+		if err != nil {
+			return err
+		}
+	}
+
 	return am.DB.QueryRowContext(ctx, query, args...).Scan(&assignment.AssignmentId)
 }
 
+func (am *AssignmentModel) courseOf(ctx context.Context, id int) (int, error) {
+	var courseID int
+	err := am.DB.QueryRowContext(ctx, `SELECT courseid FROM assignmentmodel WHERE id = $1`, id).Scan(&courseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+	return courseID, nil
+}
+
 func (am *AssignmentModel) FetchAssignmentsByCourse(courseId int) ([]Assignment, error) {
 	query := `
     SELECT 
@@ -159,7 +193,7 @@ func (am *AssignmentModel) Get(id int) (*Assignment, error) {
 	defer cancel()
 
 	assignment := &Assignment{}
-	err := am.DB.QueryRowContext(ctx, query, id).Scan(&assignment.CourseId, &assignment.Title, &assignment.Description, &assignment.AssignmentId)
+	err := am.DB.QueryRowContext(ctx, query, id).Scan(&assignment.AssignmentId, &assignment.Title, &assignment.Description, &assignment.CourseId)
 	if err != nil { // nil => null
 		if err == sql.ErrNoRows {
 			// The course was not found
@@ -172,15 +206,44 @@ func (am *AssignmentModel) Get(id int) (*Assignment, error) {
 
 	return assignment, nil
 }
-func (am *AssignmentModel) Delete(id int) error {
+func (am *AssignmentModel) Delete(id int, actor Actor) error {
 	// Delete a specific course from the database.
 	query := `
         DELETE FROM assignmentmodel
         WHERE id = $1
+        RETURNING id, title, description, courseid
         `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := am.DB.ExecContext(ctx, query, id)
-	return err
+	courseID, err := am.courseOf(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	var assignment Assignment
+	err = tx.QueryRowContext(ctx, query, id).Scan(&assignment.AssignmentId, &assignment.Title, &assignment.Description, &assignment.CourseId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, AuditAssignmentDelete, "assignment", id, assignment, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,248 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Actor is who made a change, as recorded in the audit log. A zero UserID
+// means nobody was logged in.
+type Actor struct {
+	UserID    int64
+	IP        string
+	RequestID string
+}
+
+// Audited actions.
+const (
+	AuditLogin                = "login"
+	AuditLoginFailed          = "login.failed"
+	AuditCourseDelete         = "course.delete"
+	AuditAssignmentDelete     = "assignment.delete"
+	AuditStudentDelete        = "student.delete"
+	AuditUserDelete           = "user.delete"
+	AuditUserActivation       = "user.activation"
+	AuditRoleGrant            = "role.grant"
+	AuditRoleRevoke           = "role.revoke"
+	AuditRoleTwoFactor        = "role.require_2fa"
+	AuditBanCreate            = "ban.create"
+	AuditBanLift              = "ban.lift"
+	AuditPrerequisiteOverride = "enrollment.prerequisite_override"
+)
+
+// AuditEntry is one row of the audit log. Before and After only hold the
+// fields that changed; a created resource has no Before and a deleted one
+// no After.
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	ActorID      *int64          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	IP           string          `json:"ip"`
+	RequestID    string          `json:"request_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows down the audit log. Zero fields match everything.
+type AuditFilter struct {
+	ActorID      int64
+	ResourceType string
+	ResourceID   string
+	Since        time.Time
+	Until        time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// writeAudit appends an entry to the audit log. Call it with the
+// transaction that makes the change, so that there is no change without
+// an entry and no entry without a change.
+func writeAudit(ctx context.Context, db execer, actor Actor, action, resourceType string, resourceID interface{}, before, after interface{}) error {
+	beforeJSON, afterJSON, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO audit_log (actor_id, action, resource_type, resource_id, before, after, ip, request_id)
+	VALUES (NULLIF($1::bigint, 0), $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []interface{}{actor.UserID, action, resourceType, fmt.Sprint(resourceID), beforeJSON, afterJSON, actor.IP, actor.RequestID}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// auditDiff marshals before and after to JSON objects and drops the fields
+// that are the same in both. A nil side stays NULL.
+func auditDiff(before, after interface{}) (interface{}, interface{}, error) {
+	b, err := toJSONObject(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toJSONObject(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b != nil && a != nil {
+		for key, value := range b {
+			if other, ok := a[key]; ok && reflect.DeepEqual(value, other) {
+				delete(b, key)
+				delete(a, key)
+			}
+		}
+	}
+
+	beforeJSON, err := marshalJSONObject(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := marshalJSONObject(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal(js, &object)
+	return object, err
+}
+
+// marshalJSONObject returns the object as a JSON string, or nil for SQL
+// NULL. A string rather than []byte, which would be sent as bytea.
+func marshalJSONObject(object map[string]interface{}) (interface{}, error) {
+	if object == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	return string(js), nil
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	where := `
+	WHERE (actor_id = $1 OR $1 = 0)
+	AND (resource_type = $2 OR $2 = '')
+	AND (resource_id = $3 OR $3 = '')
+	AND (created_at >= $4 OR $4 IS NULL)
+	AND (created_at < $5 OR $5 IS NULL)`
+
+	return where, []interface{}{f.ActorID, f.ResourceType, f.ResourceID, nullTime(f.Since), nullTime(f.Until)}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// List returns one page of matching entries, newest first.
+func (m AuditModel) List(filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	where, args := filter.where()
+	query := `
+	SELECT count(*) OVER(), id, actor_id, action, resource_type, resource_id, before, after, ip, request_id, created_at
+	FROM audit_log` + where + `
+	ORDER BY id DESC
+	LIMIT $6 OFFSET $7`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args = append(args, filters.limit(), filters.offset())
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err := scanAuditEntry(rows, &entry, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// Export calls fn for every matching entry, oldest first. It stops at the
+// first error fn returns.
+func (m AuditModel) Export(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error {
+	where, args := filter.where()
+	query := `
+	SELECT id, actor_id, action, resource_type, resource_id, before, after, ip, request_id, created_at
+	FROM audit_log` + where + `
+	ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		err := scanAuditEntry(rows, &entry)
+		if err != nil {
+			return err
+		}
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanAuditEntry(row interface{ Scan(...interface{}) error }, entry *AuditEntry, extra ...interface{}) error {
+	var actorID sql.NullInt64
+	var before, after []byte
+	dest := append(extra,
+		&entry.ID,
+		&actorID,
+		&entry.Action,
+		&entry.ResourceType,
+		&entry.ResourceID,
+		&before,
+		&after,
+		&entry.IP,
+		&entry.RequestID,
+		&entry.CreatedAt,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	if actorID.Valid {
+		entry.ActorID = &actorID.Int64
+	}
+	entry.Before = before
+	entry.After = after
+	return nil
+}
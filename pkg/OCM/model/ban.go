@@ -1,6 +1,8 @@
 package model
 
 import (
+	"OCM/pkg/OCM/validator"
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -10,36 +12,186 @@ type BanModel struct {
 	DB *sql.DB
 }
 type Ban struct {
-	Id     int64     `json:"id"`
-	UserId int64     `json:"user_id"`
-	Expiry time.Time `json:"expiry"`
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"user_id"`
+	Username  string    `json:"username,omitempty"`
+	Reason    string    `json:"reason"`
+	BannedBy  int64     `json:"banned_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
 }
 
 var (
 	ErrDuplicateBan = errors.New("duplicate ban")
 )
 
-func (b BanModel) Insert(id int64, days int) (*Ban, error) {
+func ValidateBan(v *validator.Validator, days int, reason string) {
+	v.Check(days > 0, "days", "must be greater than zero")
+	v.Check(days <= 3650, "days", "must not be more than 3650")
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// Insert bans the user for the given number of days. A user can only have
+// one ban at a time; an expired ban is replaced, an active one results in
+// ErrDuplicateBan.
+func (b BanModel) Insert(id int64, days int, reason string, actor Actor) (*Ban, error) {
 	query := `
-	insert into bans (user_id, expiry)
-	values ($1, $2)
-	returning id, user_id, expiry`
+	insert into bans (user_id, expiry, reason, banned_by, created_at)
+	values ($1, $2, $3, $4, now())
+	on conflict (user_id) do update
+	set expiry = excluded.expiry, reason = excluded.reason, banned_by = excluded.banned_by, created_at = excluded.created_at
+	where bans.expiry <= now()
+	returning id, user_id, reason, coalesce(banned_by, 0), created_at, expiry`
 
-	args := []interface{}{id, time.Now().Add(time.Duration(24 * time.Hour))}
+	args := []interface{}{id, time.Now().Add(time.Duration(days) * 24 * time.Hour), reason, actor.UserID}
 	var ban Ban
 
-	err := b.DB.QueryRow(query, args...).Scan(
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&ban.Id,
 		&ban.UserId,
+		&ban.Reason,
+		&ban.BannedBy,
+		&ban.CreatedAt,
 		&ban.Expiry,
 	)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "unique_ban"`:
+		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrDuplicateBan
 		default:
 			return nil, err
 		}
 	}
+
+	err = writeAudit(ctx, tx, actor, AuditBanCreate, "ban", ban.Id, nil, ban)
+	if err != nil {
+		return nil, err
+	}
+	return &ban, tx.Commit()
+}
+
+// GetActive returns the user's ban if it hasn't expired yet.
+func (b BanModel) GetActive(userID int64) (*Ban, error) {
+	query := `
+	select id, user_id, reason, coalesce(banned_by, 0), created_at, expiry
+	from bans
+	where user_id = $1 and expiry > now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ban Ban
+	err := b.DB.QueryRowContext(ctx, query, userID).Scan(
+		&ban.Id,
+		&ban.UserId,
+		&ban.Reason,
+		&ban.BannedBy,
+		&ban.CreatedAt,
+		&ban.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
 	return &ban, nil
 }
+
+func (b BanModel) ListActive(filters Filters) ([]*Ban, Metadata, error) {
+	query := `
+	select count(*) over(), bans.id, bans.user_id, users.username, bans.reason, coalesce(bans.banned_by, 0), bans.created_at, bans.expiry
+	from bans
+	inner join users on users.id = bans.user_id
+	where bans.expiry > now()
+	order by bans.expiry asc
+	limit $1 offset $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	bans := []*Ban{}
+	for rows.Next() {
+		var ban Ban
+		err := rows.Scan(
+			&totalRecords,
+			&ban.Id,
+			&ban.UserId,
+			&ban.Username,
+			&ban.Reason,
+			&ban.BannedBy,
+			&ban.CreatedAt,
+			&ban.Expiry,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		bans = append(bans, &ban)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return bans, metadata, nil
+}
+
+// Lift ends an active ban early.
+func (b BanModel) Lift(id int64, actor Actor) error {
+	query := `
+	delete from bans
+	where id = $1 and expiry > now()
+	returning id, user_id, reason, coalesce(banned_by, 0), created_at, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ban Ban
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&ban.Id,
+		&ban.UserId,
+		&ban.Reason,
+		&ban.BannedBy,
+		&ban.CreatedAt,
+		&ban.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = writeAudit(ctx, tx, actor, AuditBanLift, "ban", ban.Id, ban, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
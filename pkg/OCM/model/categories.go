@@ -0,0 +1,262 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateCategory   = errors.New("a category with this name already exists under the same parent")
+	ErrCategoryCycle       = errors.New("a category cannot be moved under itself")
+	ErrCategoryHasChildren = errors.New("category has subcategories")
+	ErrCategoryNotFound    = errors.New("category does not exist")
+)
+
+// Category groups courses in the catalog. Categories form a tree; ParentID
+// is nil for the top level. A course in a category is also found under all
+// the categories above it.
+type Category struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int64 `json:"parent_id"`
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 100, "name", "must not be more than 100 bytes long")
+	if category.ParentID != nil {
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) List() ([]*Category, error) {
+	query := `
+	SELECT id, name, parent_id
+	FROM categories
+	ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	query := `
+	SELECT id, name, parent_id
+	FROM categories
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var category Category
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&category.ID, &category.Name, &category.ParentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &category, nil
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+	INSERT INTO categories (name, parent_id)
+	VALUES ($1, $2)
+	RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, category.Name, category.ParentID).Scan(&category.ID)
+	return categoryError(err)
+}
+
+// Update renames the category or moves it to another parent. It returns
+// ErrCategoryCycle if the new parent is the category or one below it.
+func (m CategoryModel) Update(category *Category) error {
+	query := `
+	WITH RECURSIVE subtree (id) AS (
+		SELECT $1::bigint
+		UNION
+		SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+	)
+	SELECT EXISTS(SELECT 1 FROM subtree WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		// Two moves that are fine on their own can make a cycle together,
+		// so only one transaction at a time may move categories.
+		_, err = tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+
+		var cycle bool
+		err = tx.QueryRowContext(ctx, query, category.ID, *category.ParentID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE categories SET name = $1, parent_id = $2 WHERE id = $3`, category.Name, category.ParentID, category.ID)
+	if err != nil {
+		return categoryError(err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the category from the catalog and from its courses. A
+// category with subcategories cannot be deleted; they have to be moved or
+// deleted first.
+func (m CategoryModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, id)
+	if err != nil {
+		return categoryError(err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListForCourse returns the categories the course was put in.
+func (m CategoryModel) ListForCourse(courseID int) ([]*Category, error) {
+	query := `
+	SELECT c.id, c.name, c.parent_id
+	FROM course_categories cc
+	JOIN categories c ON c.id = cc.category_id
+	WHERE cc.course_id = $1
+	ORDER BY c.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// SetForCourse replaces the categories of the course. It returns
+// ErrCategoryNotFound if one of them does not exist.
+func (m CategoryModel) SetForCourse(courseID int, categoryIDs []int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM course_categories WHERE course_id = $1`, courseID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO course_categories (course_id, category_id)
+	SELECT $1::integer, unnest($2::bigint[])
+	ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, courseID, pq.Array(categoryIDs))
+	if err != nil {
+		return categoryError(err)
+	}
+
+	return tx.Commit()
+}
+
+// categoryError translates the constraint violations of the categories
+// tables.
+func categoryError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err.Error() {
+	case `pq: duplicate key value violates unique constraint "categories_parent_name_idx"`:
+		return ErrDuplicateCategory
+	case `pq: insert or update on table "categories" violates foreign key constraint "categories_parent_id_fkey"`,
+		`pq: insert or update on table "course_categories" violates foreign key constraint "course_categories_category_id_fkey"`:
+		return ErrCategoryNotFound
+	case `pq: update or delete on table "categories" violates foreign key constraint "categories_parent_id_fkey" on table "categories"`:
+		return ErrCategoryHasChildren
+	}
+	return err
+}
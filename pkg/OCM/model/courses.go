@@ -1,18 +1,98 @@
 package model
 
 import (
+	"OCM/pkg/OCM/validator"
 	"context"
 	"database/sql"
 	"errors"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Course struct {
-	CourseId       int    `json:"courseid"`
-	Title          string `json:"title"`
-	Description    string `json:"description"`
+	CourseId    int    `json:"courseid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// CourseDuration is the old free-text duration such as "3 month". It is
+	// still stored for clients that send it, but sorting and filtering use
+	// the dates below.
 	CourseDuration string `json:"courseduration"`
+	// Capacity is how many students can be active in the course at once;
+	// nil means there is no limit.
+	Capacity    *int     `json:"capacity"`
+	StartDate   *Date    `json:"start_date"`
+	EndDate     *Date    `json:"end_date"`
+	WeeklyHours *float64 `json:"weekly_hours"`
+	// DurationWeeks is computed from the dates when both are set, and
+	// otherwise parsed from CourseDuration if it can be.
+	DurationWeeks *int `json:"duration_weeks"`
+	// Categories and Tags are only filled in by List.
+	Categories []*Category `json:"categories,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+}
+
+// courseColumns lists the columns scanned by Course.fields, prefixed with
+// the alias of the course table if there is one.
+func courseColumns(alias string) string {
+	columns := []string{"courseid", "title", "description", "courseduration", "capacity", "start_date", "end_date", "weekly_hours", "duration_weeks"}
+	if alias != "" {
+		for i := range columns {
+			columns[i] = alias + "." + columns[i]
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+func (c *Course) fields() []interface{} {
+	return []interface{}{&c.CourseId, &c.Title, &c.Description, &c.CourseDuration, &c.Capacity, &c.StartDate, &c.EndDate, &c.WeeklyHours, &c.DurationWeeks}
+}
+
+// setDuration computes DurationWeeks from the dates, counting both the
+// first and the last day and rounding up to whole weeks. Without both dates
+// it falls back to CourseDuration, the same way the migration did for the
+// courses from before there were dates, and to nil if that can't be read.
+func (c *Course) setDuration() {
+	if c.StartDate == nil || c.EndDate == nil {
+		c.DurationWeeks = parseCourseDuration(c.CourseDuration)
+		return
+	}
+	days := int(c.EndDate.Sub(c.StartDate.Time).Hours()/24) + 1
+	weeks := (days + 6) / 7
+	c.DurationWeeks = &weeks
+}
+
+var courseDurationRX = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*(day|week|month|year)s?\s*$`)
+
+// parseCourseDuration reads a free-text duration like "3 month" or
+// "10 weeks" as a number of weeks. A month is counted as 52/12 weeks.
+func parseCourseDuration(duration string) *int {
+	m := courseDurationRX.FindStringSubmatch(strings.ToLower(duration))
+	if m == nil {
+		return nil
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return nil
+	}
+
+	var weeks float64
+	switch m[2] {
+	case "day":
+		weeks = math.Ceil(n / 7)
+	case "week":
+		weeks = math.Round(n)
+	case "month":
+		weeks = math.Round(n * 52 / 12)
+	case "year":
+		weeks = math.Round(n * 52)
+	}
+	w := int(weeks)
+	return &w
 }
 
 var courses = []Course{
@@ -43,10 +123,77 @@ func GetCourses() []Course {
 	return courses
 }
 
+var ErrNotPermitted = errors.New("not permitted")
+
+func ValidateCourseCapacity(v *validator.Validator, course *Course) {
+	if course.Capacity != nil {
+		v.Check(*course.Capacity >= 0, "capacity", "must not be negative")
+	}
+}
+
+func ValidateCourseSchedule(v *validator.Validator, course *Course) {
+	if course.StartDate != nil && course.EndDate != nil {
+		v.Check(!course.EndDate.Before(course.StartDate.Time), "end_date", "must not be before the start date")
+	}
+	if course.WeeklyHours != nil {
+		v.Check(*course.WeeklyHours > 0, "weekly_hours", "must be positive")
+		v.Check(*course.WeeklyHours <= 168, "weekly_hours", "must not be more than the hours in a week")
+	}
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// authorizeCourse checks that the user either teaches the course or holds
+// the courses:manage permission. Every model method that modifies a course
+// or anything belonging to one goes through here.
+func authorizeCourse(ctx context.Context, q rowQuerier, courseID int, userID int64) error {
+	query := `
+	SELECT
+		EXISTS(SELECT 1 FROM course WHERE courseid = $1),
+		EXISTS(SELECT 1 FROM course_instructors WHERE course_id = $1 AND user_id = $2)
+		OR EXISTS(
+			SELECT 1 FROM user_roles
+			INNER JOIN role_permissions ON role_permissions.role_id = user_roles.role_id
+			INNER JOIN permissions ON permissions.id = role_permissions.permission_id
+			WHERE user_roles.user_id = $2 AND permissions.code = 'courses:manage'
+		)`
+
+	var exists, permitted bool
+	err := q.QueryRowContext(ctx, query, courseID, userID).Scan(&exists, &permitted)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+	if !permitted {
+		return ErrNotPermitted
+	}
+	return nil
+}
+
+// Authorize reports whether the user may modify the course. It returns
+// ErrRecordNotFound if there is no such course and ErrNotPermitted if the
+// user neither teaches it nor may manage every course.
+func (cm *CourseModel) Authorize(courseID int, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return authorizeCourse(ctx, cm.DB, courseID, userID)
+}
+
 func (cm *CourseModel) Get(id int) (*Course, error) {
 	// Query the course from the database.
 	query := `
-        SELECT courseid, title, description, courseduration
+        SELECT ` + courseColumns("") + `
         FROM course
         WHERE courseid = $1
     `
@@ -54,7 +201,7 @@ func (cm *CourseModel) Get(id int) (*Course, error) {
 	defer cancel()
 
 	course := &Course{}
-	err := cm.DB.QueryRowContext(ctx, query, id).Scan(&course.CourseId, &course.Title, &course.Description, &course.CourseDuration)
+	err := cm.DB.QueryRowContext(ctx, query, id).Scan(course.fields()...)
 	if err != nil { // nil => null
 		if err == sql.ErrNoRows {
 			// The course was not found
@@ -68,83 +215,362 @@ func (cm *CourseModel) Get(id int) (*Course, error) {
 	return course, nil
 }
 
-func (cm *CourseModel) Insert(course *Course) error {
+// Insert creates the course and makes ownerID its first instructor.
+func (cm *CourseModel) Insert(course *Course, ownerID int64) error {
 	// Insert a new course into the database.
 	query := `
-		INSERT INTO course (title, description, courseduration) 
-		VALUES ($1, $2, $3) 
+		INSERT INTO course (title, description, courseduration, capacity, start_date, end_date, weekly_hours, duration_weeks) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
 		RETURNING courseid
 		`
-	args := []interface{}{course.Title, course.Description, course.CourseDuration}
+	course.setDuration()
+	args := []interface{}{course.Title, course.Description, course.CourseDuration, course.Capacity, course.StartDate, course.EndDate, course.WeeklyHours, course.DurationWeeks}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return cm.DB.QueryRowContext(ctx, query, args...).Scan(&course.CourseId)
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&course.CourseId)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO course_instructors (course_id, user_id)
+		VALUES ($1, $2)
+		`
+	_, err = tx.ExecContext(ctx, query, course.CourseId, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (cm *CourseModel) Update(course *Course) error {
+// Update saves the course. If that frees seats, because the capacity was
+// raised or removed, waitlisted students are promoted into them; they are
+// returned so that they can be notified.
+func (cm *CourseModel) Update(course *Course, userID int64) ([]*WaitlistPromotion, error) {
 	// Update a specific course in the database.
 	query := `
         UPDATE course
-        SET title = $1, description = $2, courseduration = $3
-        WHERE courseid = $4
+        SET title = $1, description = $2, courseduration = $3, capacity = $4,
+            start_date = $5, end_date = $6, weekly_hours = $7, duration_weeks = $8
+        WHERE courseid = $9
         RETURNING courseid
         `
-	args := []interface{}{course.Title, course.Description, course.CourseDuration, course.CourseId}
+	course.setDuration()
+	args := []interface{}{course.Title, course.Description, course.CourseDuration, course.Capacity, course.StartDate, course.EndDate, course.WeeklyHours, course.DurationWeeks, course.CourseId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return cm.DB.QueryRowContext(ctx, query, args...).Scan(&course.CourseId)
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, course.CourseId, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&course.CourseId)
+	if err != nil {
+		return nil, err
+	}
+
+	promotions, err := promoteWaitlisted(ctx, tx, course.CourseId)
+	if err != nil {
+		return nil, err
+	}
+	return promotions, tx.Commit()
 }
 
-func (cm *CourseModel) Delete(id int) error {
+func (cm *CourseModel) Delete(id int, actor Actor) error {
 	// Delete a specific course from the database.
 	query := `
         DELETE FROM course
         WHERE courseid = $1
+        RETURNING ` + courseColumns("") + `
         `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := cm.DB.ExecContext(ctx, query, id)
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, id, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	var course Course
+	err = tx.QueryRowContext(ctx, query, id).Scan(course.fields()...)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, AuditCourseDelete, "course", id, course, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListTaughtBy returns the courses the user is an instructor of.
+func (cm *CourseModel) ListTaughtBy(userID int64) ([]*Course, error) {
+	query := `
+        SELECT ` + courseColumns("c") + `
+        FROM course c
+        JOIN course_instructors ci ON ci.course_id = c.courseid
+        WHERE ci.user_id = $1
+        ORDER BY c.courseid
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	courses := []*Course{}
+	for rows.Next() {
+		var course Course
+		if err := rows.Scan(course.fields()...); err != nil {
+			return nil, err
+		}
+		courses = append(courses, &course)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+// ListEnrolled returns the courses the student linked to the user is
+// enrolled in or has completed.
+func (cm *CourseModel) ListEnrolled(userID int64) ([]*Course, error) {
+	query := `
+        SELECT ` + courseColumns("c") + `
+        FROM course c
+        JOIN student_course sc ON sc.courseid = c.courseid
+        JOIN student s ON s.studentid = sc.studentid
+        WHERE s.user_id = $1 AND sc.status IN ('active', 'completed')
+        ORDER BY c.courseid
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	courses := []*Course{}
+	for rows.Next() {
+		var course Course
+		if err := rows.Scan(course.fields()...); err != nil {
+			return nil, err
+		}
+		courses = append(courses, &course)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+// Instructor is a user teaching a course.
+type Instructor struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func (cm *CourseModel) Instructors(courseID int) ([]*Instructor, error) {
+	query := `
+        SELECT users.id, users.username
+        FROM course_instructors ci
+        JOIN users ON users.id = ci.user_id
+        WHERE ci.course_id = $1
+        ORDER BY users.username
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instructors := []*Instructor{}
+	for rows.Next() {
+		var instructor Instructor
+		if err := rows.Scan(&instructor.UserID, &instructor.Username); err != nil {
+			return nil, err
+		}
+		instructors = append(instructors, &instructor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return instructors, nil
+}
+
+func (cm *CourseModel) AddInstructor(courseID int, instructorID, userID int64) error {
+	query := `
+        INSERT INTO course_instructors (course_id, user_id)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := authorizeCourse(ctx, cm.DB, courseID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = cm.DB.ExecContext(ctx, query, courseID, instructorID)
 	return err
 }
 
-func (cm *CourseModel) List(page, pageSize int, filter, sort string) ([]*Course, error) {
-	var courses []*Course
+func (cm *CourseModel) RemoveInstructor(courseID int, instructorID, userID int64) error {
+	query := `
+        DELETE FROM course_instructors
+        WHERE course_id = $1 AND user_id = $2
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	baseQuery := `SELECT courseid, title, description, courseduration FROM course`
-	whereClauses, args := []string{}, []interface{}{}
+	err := authorizeCourse(ctx, cm.DB, courseID, userID)
+	if err != nil {
+		return err
+	}
 
-	// Фильтрация
-	if filter != "" {
-		whereClauses = append(whereClauses, "title ILIKE $1")
-		args = append(args, "%"+filter+"%")
+	result, err := cm.DB.ExecContext(ctx, query, courseID, instructorID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CourseFilter narrows down List. Zero fields match everything.
+type CourseFilter struct {
+	Title        string
+	StartsAfter  *Date
+	StartsBefore *Date
+	// Running only matches courses that have started and not ended yet.
+	Running bool
+	// Categories matches courses in any of the categories or below them,
+	// Tags courses with any of the tags.
+	Categories []int64
+	Tags       []string
+}
+
+// Facets that CourseFilter.conditions can leave out.
+const (
+	facetCategories = "categories"
+	facetTags       = "tags"
+)
+
+// conditions returns the conditions of the filter on the course table,
+// adding their arguments with param. The condition on the facet named by
+// except is left out.
+func (f CourseFilter) conditions(param func(interface{}) string, except string) []string {
+	var conditions []string
+	if f.Title != "" {
+		conditions = append(conditions, "title ILIKE "+param("%"+f.Title+"%"))
+	}
+	if f.StartsAfter != nil {
+		conditions = append(conditions, "start_date > "+param(f.StartsAfter))
 	}
+	if f.StartsBefore != nil {
+		conditions = append(conditions, "start_date < "+param(f.StartsBefore))
+	}
+	if f.Running {
+		conditions = append(conditions, "start_date <= current_date AND (end_date IS NULL OR end_date >= current_date)")
+	}
+	if len(f.Categories) > 0 && except != facetCategories {
+		conditions = append(conditions, `courseid IN (
+			WITH RECURSIVE subtree (id) AS (
+				SELECT unnest(`+param(pq.Array(f.Categories))+`::bigint[])
+				UNION
+				SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+			)
+			SELECT cc.course_id FROM course_categories cc JOIN subtree s ON s.id = cc.category_id
+		)`)
+	}
+	if len(f.Tags) > 0 && except != facetTags {
+		conditions = append(conditions, "courseid IN (SELECT course_id FROM course_tags WHERE tag = ANY("+param(pq.Array(f.Tags))+"::text[]))")
+	}
+	return conditions
+}
 
-	// Добавляем WHERE только если есть условия фильтрации
-	if len(whereClauses) > 0 {
-		baseQuery += " WHERE " + strings.Join(whereClauses, " AND ")
+// where joins conditions into a WHERE clause, or nothing if there are none.
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
 	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// queryArgs collects the arguments of a query built piece by piece.
+type queryArgs []interface{}
+
+// param adds an argument and returns its placeholder.
+func (a *queryArgs) param(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+func (cm *CourseModel) List(page, pageSize int, filter CourseFilter, sort string) ([]*Course, error) {
+	var courses []*Course
+
+	var args queryArgs
+
+	// Фильтрация
+	baseQuery := `SELECT ` + courseColumns("") + ` FROM course` + where(filter.conditions(args.param, ""))
 
 	// Сортировка
-	orderBy := " ORDER BY course_id ASC" // default sort by course_id in ascending order
+	orderBy := " ORDER BY courseid ASC" // default sort by courseid in ascending order
 	if sort != "" {
 		switch sort {
 		case "title_asc":
-			orderBy = " ORDER BY title ASC"
+			orderBy = " ORDER BY title ASC, courseid"
 		case "title_desc":
-			orderBy = " ORDER BY title DESC"
+			orderBy = " ORDER BY title DESC, courseid"
 		case "duration_asc":
-			orderBy = " ORDER BY course_duration ASC"
+			orderBy = " ORDER BY duration_weeks ASC NULLS LAST, courseid"
 		case "duration_desc":
-			orderBy = " ORDER BY course_duration DESC"
+			orderBy = " ORDER BY duration_weeks DESC NULLS LAST, courseid"
+		case "start_asc":
+			orderBy = " ORDER BY start_date ASC NULLS LAST, courseid"
+		case "start_desc":
+			orderBy = " ORDER BY start_date DESC NULLS LAST, courseid"
 		}
 	}
 
 	// Пагинация
-	pagination := " LIMIT $2 OFFSET $3"
-	args = append(args, pageSize, (page-1)*pageSize)
+	pagination := " LIMIT " + args.param(pageSize) + " OFFSET " + args.param((page-1)*pageSize)
 
 	finalQuery := baseQuery + orderBy + pagination
 
@@ -156,7 +582,7 @@ func (cm *CourseModel) List(page, pageSize int, filter, sort string) ([]*Course,
 
 	for rows.Next() {
 		var course Course
-		if err := rows.Scan(&course.CourseId, &course.Title, &course.Description, &course.CourseDuration); err != nil {
+		if err := rows.Scan(course.fields()...); err != nil {
 			return nil, err
 		}
 		courses = append(courses, &course)
@@ -166,12 +592,17 @@ func (cm *CourseModel) List(page, pageSize int, filter, sort string) ([]*Course,
 		return nil, err
 	}
 
+	err = cm.fillTaxonomy(courses)
+	if err != nil {
+		return nil, err
+	}
+
 	return courses, nil
 }
 
 func (cm *CourseModel) AllList() ([]*Course, error) {
 	var courses []*Course
-	baseQuery := `SELECT courseid, title, description, courseduration FROM course`
+	baseQuery := `SELECT ` + courseColumns("") + ` FROM course`
 	rows, err := cm.DB.Query(baseQuery)
 	if err != nil {
 		return nil, err // Properly return the error if the query execution fails
@@ -181,7 +612,7 @@ func (cm *CourseModel) AllList() ([]*Course, error) {
 	for rows.Next() {
 		var course Course
 		// Scanning each row into a Course struct
-		if err := rows.Scan(&course.CourseId, &course.Title, &course.Description, &course.CourseDuration); err != nil {
+		if err := rows.Scan(course.fields()...); err != nil {
 			return nil, err // Return an error if any occurs during row scanning
 		}
 		courses = append(courses, &course) // Append each course to the slice
@@ -0,0 +1,50 @@
+package model
+
+import "testing"
+
+func TestCourseSetDuration(t *testing.T) {
+	date := func(s string) *Date {
+		d, err := ParseDate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	weeks := func(n int) *int { return &n }
+
+	tests := []struct {
+		name   string
+		course Course
+		want   *int
+	}{
+		{"one day", Course{StartDate: date("2024-09-02"), EndDate: date("2024-09-02")}, weeks(1)},
+		{"exactly one week", Course{StartDate: date("2024-09-02"), EndDate: date("2024-09-08")}, weeks(1)},
+		{"one week and a day", Course{StartDate: date("2024-09-02"), EndDate: date("2024-09-09")}, weeks(2)},
+		{"dates win over the text", Course{StartDate: date("2024-09-02"), EndDate: date("2024-09-15"), CourseDuration: "3 month"}, weeks(2)},
+		{"months", Course{CourseDuration: "3 month"}, weeks(13)},
+		{"plural and case", Course{CourseDuration: " 10 Weeks "}, weeks(10)},
+		{"days round up", Course{CourseDuration: "8 days"}, weeks(2)},
+		{"fractional years", Course{CourseDuration: "1.5 years"}, weeks(78)},
+		{"only a start date", Course{StartDate: date("2024-09-02"), CourseDuration: "4 month"}, weeks(17)},
+		{"unreadable text", Course{CourseDuration: "one semester"}, nil},
+		{"no duration at all", Course{}, nil},
+		{"stale value is cleared", Course{EndDate: date("2024-09-02"), DurationWeeks: weeks(5)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.course.setDuration()
+			got := tt.course.DurationWeeks
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("DurationWeeks = %v, want %v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func deref(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
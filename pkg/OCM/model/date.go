@@ -0,0 +1,60 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DateLayout is how dates are written in JSON and query strings.
+const DateLayout = "2006-01-02"
+
+// Date is a calendar day without a time of day, stored in a date column
+// and written as "2006-01-02" in JSON.
+type Date struct {
+	time.Time
+}
+
+// ParseDate parses a date in DateLayout.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
+func (d Date) String() string {
+	return d.Format(DateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return fmt.Errorf("date must be a string in the form %s", DateLayout)
+	}
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return fmt.Errorf("date must be in the form %s", DateLayout)
+	}
+	*d = parsed
+	return nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a date", src)
+	}
+	*d = Date{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
@@ -0,0 +1,404 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Enrollment statuses. Dropping a student keeps the row, so that their
+// history in the course is not lost and they can be enrolled again.
+// Students are waitlisted when the course is full and become active, in
+// the order they were waitlisted, as seats free up.
+const (
+	EnrollmentActive     = "active"
+	EnrollmentWaitlisted = "waitlisted"
+	EnrollmentDropped    = "dropped"
+	EnrollmentCompleted  = "completed"
+)
+
+var (
+	ErrAlreadyEnrolled = errors.New("student is already enrolled")
+	ErrStudentNotFound = errors.New("student does not exist")
+	ErrCourseFull      = errors.New("course is full")
+)
+
+// Enrollment is a row of student_course. Course is only filled in when
+// listing the courses of a student, Student when listing a course.
+// WaitlistPosition starts at 1 and is only set for waitlisted students.
+type Enrollment struct {
+	StudentID        int       `json:"studentid"`
+	CourseID         int       `json:"courseid"`
+	Status           string    `json:"status"`
+	WaitlistPosition *int      `json:"waitlist_position,omitempty"`
+	EnrolledAt       time.Time `json:"enrolled_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Course           *Course   `json:"course,omitempty"`
+	Student          *Student  `json:"student,omitempty"`
+}
+
+// WaitlistPromotion is a waitlisted student who got a seat. Email is the
+// address of the user linked to the student, if there is one.
+type WaitlistPromotion struct {
+	StudentID   int
+	StudentName string
+	CourseID    int
+	CourseTitle string
+	Email       string
+}
+
+// ValidateEnrollmentStatus checks a status set by hand. Nobody can put a
+// student on the waitlist but the course filling up.
+func ValidateEnrollmentStatus(v *validator.Validator, status string) {
+	v.Check(validator.In(status, EnrollmentActive, EnrollmentDropped, EnrollmentCompleted), "status", "must be active, dropped or completed")
+}
+
+// waitlistPosition computes the waitlist position of the student_course
+// row sc.
+const waitlistPosition = `
+	CASE WHEN sc.status = 'waitlisted' THEN (
+		SELECT count(*) FROM student_course w
+		WHERE w.courseid = sc.courseid AND w.status = 'waitlisted' AND w.waitlist_seq <= sc.waitlist_seq
+	) END`
+
+type EnrollmentModel struct {
+	DB *sql.DB
+}
+
+// lockSeats locks the course row for the rest of the transaction, which
+// every change to who occupies its seats goes through, and returns its
+// capacity and the number of active students.
+func lockSeats(ctx context.Context, tx *sql.Tx, courseID int) (capacity *int, active int, err error) {
+	err = tx.QueryRowContext(ctx, `SELECT capacity FROM course WHERE courseid = $1 FOR UPDATE`, courseID).Scan(&capacity)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+
+	query := `SELECT count(*) FROM student_course WHERE courseid = $1 AND status = 'active'`
+	err = tx.QueryRowContext(ctx, query, courseID).Scan(&active)
+	if err != nil {
+		return nil, 0, err
+	}
+	return capacity, active, nil
+}
+
+func hasFreeSeat(capacity *int, active int) bool {
+	return capacity == nil || active < *capacity
+}
+
+// Enroll enrolls the students in the course, all of them or none. Students
+// who do not fit are waitlisted in the order given. Dropped students are
+// enrolled again; students who are active in, waitlisted for or have
+// completed the course make the whole call fail with ErrAlreadyEnrolled,
+// and so do students who have not completed the prerequisites of the
+// course with ErrPrerequisitesNotMet, unless overridePrerequisites is set.
+func (m EnrollmentModel) Enroll(courseID int, studentIDs []int, actor Actor, overridePrerequisites bool) ([]*Enrollment, error) {
+	query := `
+	INSERT INTO student_course (studentid, courseid, status, waitlist_seq)
+	VALUES ($1, $2, $3, CASE WHEN $3::text = 'waitlisted' THEN nextval('student_course_waitlist_seq') END)
+	ON CONFLICT (studentid, courseid) DO UPDATE
+	SET status = excluded.status, waitlist_seq = excluded.waitlist_seq, enrolled_at = now(), updated_at = now()
+	WHERE student_course.status = 'dropped'
+	RETURNING status, enrolled_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Holding the course row until commit is what keeps concurrent
+	// enrollments from taking the same seat.
+	capacity, active, err := lockSeats(ctx, tx, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollments := []*Enrollment{}
+	for _, studentID := range studentIDs {
+		status := EnrollmentWaitlisted
+		if hasFreeSeat(capacity, active) {
+			status = EnrollmentActive
+			active++
+		}
+
+		enrollment := Enrollment{StudentID: studentID, CourseID: courseID}
+		err := tx.QueryRowContext(ctx, query, studentID, courseID, status).Scan(&enrollment.Status, &enrollment.EnrolledAt, &enrollment.UpdatedAt)
+		if err != nil {
+			var pqErr *pq.Error
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, fmt.Errorf("student %d: %w", studentID, ErrAlreadyEnrolled)
+			case errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == "student_course_studentid_fkey":
+				return nil, fmt.Errorf("student %d: %w", studentID, ErrStudentNotFound)
+			default:
+				return nil, err
+			}
+		}
+		err = checkPrerequisites(ctx, tx, courseID, studentID, overridePrerequisites, actor)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, &enrollment)
+	}
+
+	err = setWaitlistPositions(ctx, tx, enrollments)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
+
+// SetStatus changes the status of an enrollment. Making a student active
+// needs a free seat, or ErrCourseFull is returned. A student who stops
+// being active frees their seat for the first waitlisted student, who is
+// promoted in the same transaction and returned. Making a student active
+// also needs the prerequisites of the course, as in Enroll. It returns
+// ErrRecordNotFound if the student was never enrolled in the course.
+func (m EnrollmentModel) SetStatus(courseID, studentID int, status string, actor Actor, overridePrerequisites bool) (*Enrollment, []*WaitlistPromotion, error) {
+	query := `
+	UPDATE student_course
+	SET status = $3,
+		waitlist_seq = NULL,
+		updated_at = CASE WHEN status = $3 THEN updated_at ELSE now() END
+	WHERE courseid = $1 AND studentid = $2
+	RETURNING status, enrolled_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, actor.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	capacity, active, err := lockSeats(ctx, tx, courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var oldStatus string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM student_course WHERE courseid = $1 AND studentid = $2`, courseID, studentID).Scan(&oldStatus)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	if status == EnrollmentActive && oldStatus != EnrollmentActive {
+		if !hasFreeSeat(capacity, active) {
+			return nil, nil, ErrCourseFull
+		}
+		err = checkPrerequisites(ctx, tx, courseID, studentID, overridePrerequisites, actor)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	enrollment := Enrollment{StudentID: studentID, CourseID: courseID}
+	err = tx.QueryRowContext(ctx, query, courseID, studentID, status).Scan(&enrollment.Status, &enrollment.EnrolledAt, &enrollment.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var promotions []*WaitlistPromotion
+	if oldStatus == EnrollmentActive && status != EnrollmentActive {
+		promotions, err = promoteWaitlisted(ctx, tx, courseID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &enrollment, promotions, nil
+}
+
+// promoteWaitlisted fills the free seats of the course with waitlisted
+// students, first come first served. The caller must hold the lock on the
+// course row, either through lockSeats or by having updated it.
+func promoteWaitlisted(ctx context.Context, tx *sql.Tx, courseID int) ([]*WaitlistPromotion, error) {
+	query := `
+	WITH seats AS (
+		SELECT CASE WHEN c.capacity IS NULL THEN NULL
+			ELSE greatest(c.capacity - (
+				SELECT count(*) FROM student_course WHERE courseid = c.courseid AND status = 'active'
+			), 0) END AS free
+		FROM course c
+		WHERE c.courseid = $1
+	), promoted AS (
+		UPDATE student_course sc
+		SET status = 'active', waitlist_seq = NULL, updated_at = now()
+		WHERE sc.courseid = $1 AND sc.studentid IN (
+			SELECT studentid FROM student_course
+			WHERE courseid = $1 AND status = 'waitlisted'
+			ORDER BY waitlist_seq
+			LIMIT (SELECT free FROM seats)
+		)
+		RETURNING sc.studentid
+	)
+	SELECT s.studentid, s.name, c.courseid, c.title, COALESCE(u.email, '')
+	FROM promoted p
+	JOIN student s ON s.studentid = p.studentid
+	JOIN course c ON c.courseid = $1
+	LEFT JOIN users u ON u.id = s.user_id
+	ORDER BY s.studentid`
+
+	rows, err := tx.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []*WaitlistPromotion{}
+	for rows.Next() {
+		var promotion WaitlistPromotion
+		err := rows.Scan(&promotion.StudentID, &promotion.StudentName, &promotion.CourseID, &promotion.CourseTitle, &promotion.Email)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, &promotion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// setWaitlistPositions fills in the waitlist positions of the waitlisted
+// enrollments, which all belong to one course.
+func setWaitlistPositions(ctx context.Context, tx *sql.Tx, enrollments []*Enrollment) error {
+	query := `SELECT ` + waitlistPosition + ` FROM student_course sc WHERE sc.courseid = $1 AND sc.studentid = $2`
+
+	for _, enrollment := range enrollments {
+		if enrollment.Status != EnrollmentWaitlisted {
+			continue
+		}
+		err := tx.QueryRowContext(ctx, query, enrollment.CourseID, enrollment.StudentID).Scan(&enrollment.WaitlistPosition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListForCourse returns the enrollments of the course with their students:
+// the waitlist in order after everyone else. An empty status lists all of
+// them.
+func (m EnrollmentModel) ListForCourse(courseID int, status string) ([]*Enrollment, error) {
+	query := `
+	SELECT sc.studentid, sc.courseid, sc.status, ` + waitlistPosition + `, sc.enrolled_at, sc.updated_at,
+		s.name, s.age, s.gpa, s.user_id
+	FROM student_course sc
+	JOIN student s ON s.studentid = sc.studentid
+	WHERE sc.courseid = $1 AND (sc.status = $2 OR $2 = '')
+	ORDER BY sc.waitlist_seq NULLS FIRST, s.name, sc.studentid`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, courseID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []*Enrollment{}
+	for rows.Next() {
+		var enrollment Enrollment
+		var student Student
+		var gpa sql.NullFloat64
+		err := rows.Scan(
+			&enrollment.StudentID, &enrollment.CourseID, &enrollment.Status, &enrollment.WaitlistPosition, &enrollment.EnrolledAt, &enrollment.UpdatedAt,
+			&student.Name, &student.Age, &gpa, &student.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		student.StudentID = enrollment.StudentID
+		student.GPA = gpa.Float64
+		enrollment.Student = &student
+		enrollments = append(enrollments, &enrollment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
+
+// ListForStudent returns the enrollments of the student with their courses.
+// An empty status lists all of them.
+func (m EnrollmentModel) ListForStudent(studentID int, status string) ([]*Enrollment, error) {
+	query := `
+	SELECT sc.studentid, sc.courseid, sc.status, ` + waitlistPosition + `, sc.enrolled_at, sc.updated_at,
+		c.title, c.description, c.courseduration, c.capacity,
+		c.start_date, c.end_date, c.weekly_hours, c.duration_weeks
+	FROM student_course sc
+	JOIN course c ON c.courseid = sc.courseid
+	WHERE sc.studentid = $1 AND (sc.status = $2 OR $2 = '')
+	ORDER BY sc.enrolled_at DESC, sc.courseid`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, studentID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []*Enrollment{}
+	for rows.Next() {
+		var enrollment Enrollment
+		var course Course
+		var description, duration sql.NullString
+		err := rows.Scan(
+			&enrollment.StudentID, &enrollment.CourseID, &enrollment.Status, &enrollment.WaitlistPosition, &enrollment.EnrolledAt, &enrollment.UpdatedAt,
+			&course.Title, &description, &duration, &course.Capacity,
+			&course.StartDate, &course.EndDate, &course.WeeklyHours, &course.DurationWeeks,
+		)
+		if err != nil {
+			return nil, err
+		}
+		course.CourseId = enrollment.CourseID
+		course.Description = description.String
+		course.CourseDuration = duration.String
+		enrollment.Course = &course
+		enrollments = append(enrollments, &enrollment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
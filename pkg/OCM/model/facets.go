@@ -0,0 +1,173 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTagFacets is how many tags Facets counts. The tags being filtered on
+// come first, then the most used ones.
+const maxTagFacets = 50
+
+// CourseFacets counts the courses in each category and with each tag.
+// Every count applies the whole filter except the facet it belongs to, so
+// that it says how many courses choosing that value as well would show.
+type CourseFacets struct {
+	Categories []*CategoryFacet `json:"categories"`
+	Tags       []*TagFacet      `json:"tags"`
+}
+
+// CategoryFacet counts the courses in the category or any category below
+// it. Every category is listed, so that the tree can be drawn from them.
+type CategoryFacet struct {
+	Category
+	Count int `json:"count"`
+}
+
+type TagFacet struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Facets counts the courses that match the filter by category and by tag.
+func (cm *CourseModel) Facets(filter CourseFilter) (*CourseFacets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := &CourseFacets{Categories: []*CategoryFacet{}, Tags: []*TagFacet{}}
+
+	var args queryArgs
+	query := `
+	WITH RECURSIVE ancestors (id, ancestor) AS (
+		SELECT id, id FROM categories
+		UNION ALL
+		SELECT a.id, c.parent_id
+		FROM ancestors a
+		JOIN categories c ON c.id = a.ancestor
+		WHERE c.parent_id IS NOT NULL
+	), matching AS (
+		SELECT courseid FROM course` + where(filter.conditions(args.param, facetCategories)) + `
+	)
+	SELECT cat.id, cat.name, cat.parent_id, count(DISTINCT m.courseid)
+	FROM categories cat
+	LEFT JOIN ancestors a ON a.ancestor = cat.id
+	LEFT JOIN course_categories cc ON cc.category_id = a.id
+	LEFT JOIN matching m ON m.courseid = cc.course_id
+	GROUP BY cat.id
+	ORDER BY cat.name, cat.id`
+
+	rows, err := cm.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet CategoryFacet
+		err := rows.Scan(&facet.ID, &facet.Name, &facet.ParentID, &facet.Count)
+		if err != nil {
+			return nil, err
+		}
+		facets.Categories = append(facets.Categories, &facet)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	args = nil
+	query = `
+	SELECT ct.tag, count(*)
+	FROM course_tags ct
+	WHERE ct.course_id IN (
+		SELECT courseid FROM course` + where(filter.conditions(args.param, facetTags)) + `
+	)
+	GROUP BY ct.tag
+	ORDER BY ct.tag = ANY(` + args.param(pq.Array(filter.Tags)) + `::text[]) DESC, count(*) DESC, ct.tag
+	LIMIT ` + args.param(maxTagFacets)
+
+	rows, err = cm.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet TagFacet
+		if err := rows.Scan(&facet.Tag, &facet.Count); err != nil {
+			return nil, err
+		}
+		facets.Tags = append(facets.Tags, &facet)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// fillTaxonomy fills in the categories and tags of the courses.
+func (cm *CourseModel) fillTaxonomy(courses []*Course) error {
+	if len(courses) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Course, len(courses))
+	ids := make([]int64, 0, len(courses))
+	for _, course := range courses {
+		byID[course.CourseId] = course
+		ids = append(ids, int64(course.CourseId))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+	SELECT cc.course_id, c.id, c.name, c.parent_id
+	FROM course_categories cc
+	JOIN categories c ON c.id = cc.category_id
+	WHERE cc.course_id = ANY($1::integer[])
+	ORDER BY c.name, c.id`
+
+	rows, err := cm.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var courseID int
+		var category Category
+		err := rows.Scan(&courseID, &category.ID, &category.Name, &category.ParentID)
+		if err != nil {
+			return err
+		}
+		byID[courseID].Categories = append(byID[courseID].Categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query = `
+	SELECT course_id, tag
+	FROM course_tags
+	WHERE course_id = ANY($1::integer[])
+	ORDER BY tag`
+
+	rows, err = cm.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var courseID int
+		var tag string
+		if err := rows.Scan(&courseID, &tag); err != nil {
+			return err
+		}
+		byID[courseID].Tags = append(byID[courseID].Tags, tag)
+	}
+	return rows.Err()
+}
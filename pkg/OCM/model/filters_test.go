@@ -0,0 +1,23 @@
+package model
+
+import "testing"
+
+func TestContainsPattern(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"", "%%"},
+		{"alice", "%alice%"},
+		{"100%", `%100\%%`},
+		{"a_b", `%a\_b%`},
+		{`back\slash`, `%back\\slash%`},
+		{`%_\`, `%\%\_\\%`},
+	}
+
+	for _, tt := range tests {
+		if got := containsPattern(tt.search); got != tt.want {
+			t.Errorf("containsPattern(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes new passwords. Stored hashes are verified by
+// whichever scheme made them, so the hasher can be changed at any time;
+// NeedsRehash tells which stored hashes are not up to date any more.
+type PasswordHasher interface {
+	Hash(plaintext string) ([]byte, error)
+	NeedsRehash(hash []byte) bool
+	// MaxLength is the longest password in bytes the scheme can hash
+	// without losing part of it.
+	MaxLength() int
+}
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+var passwordHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+// SetPasswordHasher sets the hasher used for new passwords. It is meant to
+// be called once at startup.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
+
+// verifyPasswordHash checks plaintext against a hash made by any of the
+// supported schemes.
+func verifyPasswordHash(hash []byte, plaintext string) (bool, error) {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		return verifyArgon2id(hash, plaintext)
+	case bytes.HasPrefix(hash, []byte("$2")):
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		return false, ErrUnknownPasswordHash
+	}
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+func (h BcryptHasher) MaxLength() int {
+	return 72
+}
+
+// Argon2idHasher hashes passwords with Argon2id and stores them in the PHC
+// string format, e.g.
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// with salt and key in unpadded standard base64. Memory is in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// argon2idParams is a parsed Argon2id PHC string.
+type argon2idParams struct {
+	memory, iterations uint32
+	parallelism        uint8
+	salt, key          []byte
+}
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	phc := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(phc), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory ||
+		params.iterations != h.Iterations ||
+		params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.SaltLength ||
+		uint32(len(params.key)) != h.KeyLength
+}
+
+// MaxLength is generous but still bounded, so that nobody can make the
+// server hash megabytes.
+func (h Argon2idHasher) MaxLength() int {
+	return 1024
+}
+
+func verifyArgon2id(hash []byte, plaintext string) (bool, error) {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func parseArgon2id(hash []byte) (*argon2idParams, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	var params argon2idParams
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+	if params.iterations == 0 || params.parallelism == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, err
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, err
+	}
+	if len(params.key) == 0 {
+		return nil, errors.New("argon2id hash has no key")
+	}
+	return &params, nil
+}
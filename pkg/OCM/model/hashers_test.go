@@ -0,0 +1,186 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2 is cheap enough to hash with in tests.
+var testArgon2 = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// phc builds an Argon2id PHC string with the given parts. The salt and key
+// are 16 and 32 zero bytes.
+func phc(version, params string) []byte {
+	const (
+		salt = "AAAAAAAAAAAAAAAAAAAAAA"
+		key  = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	)
+	return []byte(fmt.Sprintf("$argon2id$%s$%s$%s$%s", version, params, salt, key))
+}
+
+func TestParseArgon2id(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    []byte
+		want    *argon2idParams
+		wantErr error
+	}{
+		{
+			name: "valid",
+			hash: phc("v=19", "m=65536,t=3,p=2"),
+			want: &argon2idParams{memory: 65536, iterations: 3, parallelism: 2, salt: make([]byte, 16), key: make([]byte, 32)},
+		},
+		{name: "bcrypt hash", hash: []byte("$2a$12$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234"), wantErr: ErrUnknownPasswordHash},
+		{name: "argon2i", hash: []byte("$argon2i$v=19$m=65536,t=3,p=2$AAAA$AAAA"), wantErr: ErrUnknownPasswordHash},
+		{name: "missing part", hash: []byte("$argon2id$v=19$m=65536,t=3,p=2$AAAA"), wantErr: ErrUnknownPasswordHash},
+		{name: "empty", hash: nil, wantErr: ErrUnknownPasswordHash},
+		{name: "old version", hash: phc("v=16", "m=65536,t=3,p=2")},
+		{name: "malformed version", hash: phc("19", "m=65536,t=3,p=2")},
+		{name: "malformed parameters", hash: phc("v=19", "m=65536;t=3;p=2")},
+		{name: "no iterations", hash: phc("v=19", "m=65536,t=0,p=2")},
+		{name: "no parallelism", hash: phc("v=19", "m=65536,t=3,p=0")},
+		{name: "bad salt", hash: []byte("$argon2id$v=19$m=65536,t=3,p=2$!!!!$AAAA")},
+		{name: "bad key", hash: []byte("$argon2id$v=19$m=65536,t=3,p=2$AAAA$!!!!")},
+		{name: "no key", hash: []byte("$argon2id$v=19$m=65536,t=3,p=2$AAAA$")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgon2id(tt.hash)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parseArgon2id(%q) succeeded, want an error", tt.hash)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("parseArgon2id(%q) error = %v, want %v", tt.hash, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgon2id(%q): %v", tt.hash, err)
+			}
+			if got.memory != tt.want.memory || got.iterations != tt.want.iterations || got.parallelism != tt.want.parallelism ||
+				len(got.salt) != len(tt.want.salt) || len(got.key) != len(tt.want.key) {
+				t.Fatalf("parseArgon2id(%q) = %+v, want %+v", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, err := testArgon2.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := func(f func(h *Argon2idHasher)) Argon2idHasher {
+		h := testArgon2
+		f(&h)
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		hasher Argon2idHasher
+		hash   []byte
+		want   bool
+	}{
+		{"same parameters", testArgon2, hash, false},
+		{"more memory", changed(func(h *Argon2idHasher) { h.Memory = 128 }), hash, true},
+		{"more iterations", changed(func(h *Argon2idHasher) { h.Iterations = 2 }), hash, true},
+		{"more parallelism", changed(func(h *Argon2idHasher) { h.Parallelism = 2 }), hash, true},
+		{"longer salt", changed(func(h *Argon2idHasher) { h.SaltLength = 32 }), hash, true},
+		{"longer key", changed(func(h *Argon2idHasher) { h.KeyLength = 64 }), hash, true},
+		{"bcrypt hash", testArgon2, []byte("$2a$04$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234"), true},
+		{"garbage", testArgon2, []byte("garbage"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	hash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := testArgon2.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cost int
+		hash []byte
+		want bool
+	}{
+		{"same cost", bcrypt.MinCost, hash, false},
+		{"higher cost", bcrypt.MinCost + 1, hash, true},
+		{"argon2id hash", bcrypt.MinCost, argon2Hash, true},
+		{"garbage", bcrypt.MinCost, []byte("garbage"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (BcryptHasher{Cost: tt.cost}).NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordHash(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	hashers := []struct {
+		name   string
+		hasher PasswordHasher
+	}{
+		{"bcrypt", BcryptHasher{Cost: bcrypt.MinCost}},
+		{"argon2id", testArgon2},
+	}
+
+	for _, h := range hashers {
+		t.Run(h.name, func(t *testing.T) {
+			hash, err := h.hasher.Hash(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				plaintext string
+				want      bool
+			}{
+				{password, true},
+				{password + " ", false},
+				{"Correct horse battery staple", false},
+				{"", false},
+			}
+			for _, tt := range tests {
+				got, err := verifyPasswordHash(hash, tt.plaintext)
+				if err != nil {
+					t.Fatalf("verifyPasswordHash(%q): %v", tt.plaintext, err)
+				}
+				if got != tt.want {
+					t.Errorf("verifyPasswordHash(%q) = %v, want %v", tt.plaintext, got, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := verifyPasswordHash([]byte("$1$md5crypt"), password)
+		if !errors.Is(err, ErrUnknownPasswordHash) {
+			t.Errorf("verifyPasswordHash error = %v, want ErrUnknownPasswordHash", err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCLogin is an OpenID Connect login that has been started but not yet
+// completed. It is looked up by the state parameter the provider echoes
+// back, and holds the PKCE verifier and nonce for that login.
+type OIDCLogin struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+	Expiry       time.Time
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m IdentityModel) InsertLogin(login *OIDCLogin) error {
+	hash := sha256.Sum256([]byte(login.State))
+
+	query := `
+	INSERT INTO oidc_logins (state, code_verifier, nonce, expiry)
+	VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash[:], login.CodeVerifier, login.Nonce, login.Expiry)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < now()`)
+	return err
+}
+
+// TakeLogin returns the unexpired login for state and deletes it, so every
+// state can only be completed once.
+func (m IdentityModel) TakeLogin(state string) (*OIDCLogin, error) {
+	hash := sha256.Sum256([]byte(state))
+
+	query := `
+	DELETE FROM oidc_logins
+	WHERE state = $1 AND expiry > now()
+	RETURNING code_verifier, nonce, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	login := OIDCLogin{State: state}
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&login.CodeVerifier, &login.Nonce, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &login, nil
+}
+
+// GetUser returns the user linked to the subject at the issuer.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+	SELECT users.id, users.username, users.email, users.password, users.activated, users.token_hash
+	FROM users
+	INNER JOIN user_identities ON user_identities.user_id = users.id
+	WHERE user_identities.issuer = $1 AND user_identities.subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.TokenHash,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Link records that the subject at the issuer is the given user.
+func (m IdentityModel) Link(userID int64, issuer, subject string) error {
+	query := `
+	INSERT INTO user_identities (user_id, issuer, subject)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (issuer, subject) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, issuer, subject)
+	return err
+}
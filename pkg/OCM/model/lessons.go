@@ -0,0 +1,329 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Lesson is an ordered part of a module. Positions start at 1 and have no
+// gaps.
+type Lesson struct {
+	ID       int64  `json:"id"`
+	ModuleID int64  `json:"module_id"`
+	Title    string `json:"title"`
+	Body     string `json:"body"`
+	Position int    `json:"position"`
+}
+
+func ValidateLesson(v *validator.Validator, lesson *Lesson) {
+	v.Check(lesson.Title != "", "title", "must be provided")
+	v.Check(len(lesson.Title) <= 255, "title", "must not be more than 255 bytes long")
+}
+
+type LessonModel struct {
+	DB *sql.DB
+}
+
+// lockModule locks the module row for the rest of the transaction, so that
+// concurrent changes to the positions of its lessons are serialized. It
+// returns the course the module belongs to.
+func lockModule(ctx context.Context, tx *sql.Tx, moduleID int64) (int, error) {
+	var courseID int
+	err := tx.QueryRowContext(ctx, `SELECT course_id FROM course_modules WHERE id = $1 FOR UPDATE`, moduleID).Scan(&courseID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return courseID, nil
+}
+
+// lockAuthorizedModule locks the module and checks that the user may
+// modify its course.
+func lockAuthorizedModule(ctx context.Context, tx *sql.Tx, moduleID int64, userID int64) error {
+	courseID, err := lockModule(ctx, tx, moduleID)
+	if err != nil {
+		return err
+	}
+	return authorizeCourse(ctx, tx, courseID, userID)
+}
+
+func (m LessonModel) ListForModule(moduleID int64) ([]*Lesson, error) {
+	query := `
+	SELECT id, module_id, title, body, position
+	FROM lessons
+	WHERE module_id = $1
+	ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lessons := []*Lesson{}
+	for rows.Next() {
+		var lesson Lesson
+		err := rows.Scan(&lesson.ID, &lesson.ModuleID, &lesson.Title, &lesson.Body, &lesson.Position)
+		if err != nil {
+			return nil, err
+		}
+		lessons = append(lessons, &lesson)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lessons, nil
+}
+
+func (m LessonModel) Get(id int64) (*Lesson, error) {
+	query := `
+	SELECT id, module_id, title, body, position
+	FROM lessons
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lesson Lesson
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&lesson.ID, &lesson.ModuleID, &lesson.Title, &lesson.Body, &lesson.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &lesson, nil
+}
+
+// Insert adds the lesson at the end of its module.
+func (m LessonModel) Insert(lesson *Lesson, userID int64) error {
+	query := `
+	INSERT INTO lessons (module_id, title, body, position)
+	SELECT $1::bigint, $2, $3, COALESCE(MAX(position), 0) + 1
+	FROM lessons
+	WHERE module_id = $1
+	RETURNING id, position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAuthorizedModule(ctx, tx, lesson.ModuleID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, lesson.ModuleID, lesson.Title, lesson.Body).Scan(&lesson.ID, &lesson.Position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the title and body of the lesson. Positions are changed with
+// Reorder and Move.
+func (m LessonModel) Update(lesson *Lesson, userID int64) error {
+	query := `
+	UPDATE lessons
+	SET title = $1, body = $2
+	WHERE id = $3 AND module_id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAuthorizedModule(ctx, tx, lesson.ModuleID, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, lesson.Title, lesson.Body, lesson.ID, lesson.ModuleID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// The lesson was deleted or moved to another module in the meantime.
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return tx.Commit()
+}
+
+// Delete removes the lesson and closes the gap it leaves in the order of
+// its module.
+func (m LessonModel) Delete(lesson *Lesson, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAuthorizedModule(ctx, tx, lesson.ModuleID, userID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx, `DELETE FROM lessons WHERE id = $1 AND module_id = $2 RETURNING position`, lesson.ID, lesson.ModuleID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = closeLessonGap(ctx, tx, lesson.ModuleID, position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reorder puts the lessons of the module in the given order. ids must hold
+// every lesson of the module exactly once.
+func (m LessonModel) Reorder(moduleID int64, ids []int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAuthorizedModule(ctx, tx, moduleID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = reorder(ctx, tx, "lessons", "module_id", moduleID, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Move puts the lesson at the given position of a module, which may be the
+// one it is in already. Positions past the end put it last. The user must
+// be allowed to modify both courses involved.
+func (m LessonModel) Move(lesson *Lesson, moduleID int64, position int, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the modules in a fixed order so that two opposite moves cannot
+	// deadlock.
+	first, second := lesson.ModuleID, moduleID
+	if second < first {
+		first, second = second, first
+	}
+	err = lockAuthorizedModule(ctx, tx, first, userID)
+	if err != nil {
+		return err
+	}
+	if second != first {
+		err = lockAuthorizedModule(ctx, tx, second, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var oldPosition int
+	err = tx.QueryRowContext(ctx, `SELECT position FROM lessons WHERE id = $1 AND module_id = $2`, lesson.ID, lesson.ModuleID).Scan(&oldPosition)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = closeLessonGap(ctx, tx, lesson.ModuleID, oldPosition)
+	if err != nil {
+		return err
+	}
+
+	var others int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM lessons WHERE module_id = $1 AND id <> $2`, moduleID, lesson.ID).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if position > others+1 {
+		position = others + 1
+	}
+
+	query := `
+	UPDATE lessons
+	SET position = position + 1
+	WHERE module_id = $1 AND position >= $2 AND id <> $3`
+
+	_, err = tx.ExecContext(ctx, query, moduleID, position, lesson.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE lessons
+	SET module_id = $1, position = $2
+	WHERE id = $3`
+
+	_, err = tx.ExecContext(ctx, query, moduleID, position, lesson.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	lesson.ModuleID = moduleID
+	lesson.Position = position
+	return nil
+}
+
+// closeLessonGap moves up the lessons of the module after position.
+func closeLessonGap(ctx context.Context, tx *sql.Tx, moduleID int64, position int) error {
+	query := `
+	UPDATE lessons
+	SET position = position - 1
+	WHERE module_id = $1 AND position > $2`
+
+	_, err := tx.ExecContext(ctx, query, moduleID, position)
+	return err
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginState tracks failed password attempts against an account.
+type LoginState struct {
+	FailedLogins    int
+	LastFailedLogin time.Time
+	LockedUntil     time.Time
+	// JustLocked is set by RecordLoginFailure when that failure caused the
+	// account to be locked.
+	JustLocked bool
+}
+
+func (s *LoginState) Locked() bool {
+	return s.LockedUntil.After(time.Now())
+}
+
+// Lockout is the admin view of a locked account.
+type Lockout struct {
+	UserID          int64     `json:"user_id"`
+	Username        string    `json:"username"`
+	Email           string    `json:"email"`
+	FailedLogins    int       `json:"failed_logins"`
+	LastFailedLogin time.Time `json:"last_failed_login"`
+	LockedUntil     time.Time `json:"locked_until"`
+}
+
+func (u UserModel) GetLoginState(id int64) (*LoginState, error) {
+	query := `
+	SELECT failed_logins, last_failed_login, locked_until
+	FROM users
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var state LoginState
+	var lastFailed, lockedUntil sql.NullTime
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(&state.FailedLogins, &lastFailed, &lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	state.LastFailedLogin = lastFailed.Time
+	state.LockedUntil = lockedUntil.Time
+	return &state, nil
+}
+
+// RecordLoginFailure counts a failed login. Failures older than window are
+// forgotten; once maxFailures is reached the account is locked for window.
+func (u UserModel) RecordLoginFailure(id int64, maxFailures int, window time.Duration, actor Actor) (*LoginState, error) {
+	query := `
+	WITH previous AS (
+		SELECT id, locked_until,
+			CASE
+				WHEN last_failed_login IS NULL OR last_failed_login < now() - $3 * interval '1 second' THEN 1
+				ELSE failed_logins + 1
+			END AS failures
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	)
+	UPDATE users
+	SET failed_logins = previous.failures,
+		last_failed_login = now(),
+		locked_until = CASE
+			WHEN previous.failures >= $2 AND (users.locked_until IS NULL OR users.locked_until <= now())
+				THEN now() + $3 * interval '1 second'
+			ELSE users.locked_until
+		END
+	FROM previous
+	WHERE users.id = previous.id
+	RETURNING users.failed_logins, users.last_failed_login, users.locked_until,
+		users.locked_until IS DISTINCT FROM previous.locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var state LoginState
+	var lastFailed, lockedUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, query, id, maxFailures, int(window.Seconds())).Scan(
+		&state.FailedLogins,
+		&lastFailed,
+		&lockedUntil,
+		&state.JustLocked,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	state.LastFailedLogin = lastFailed.Time
+	state.LockedUntil = lockedUntil.Time
+
+	after := map[string]interface{}{"failed_logins": state.FailedLogins, "locked": state.JustLocked}
+	err = writeAudit(ctx, tx, actor, AuditLoginFailed, "user", id, nil, after)
+	if err != nil {
+		return nil, err
+	}
+	return &state, tx.Commit()
+}
+
+// ResetLoginFailures clears the failure count and lifts any lockout.
+func (u UserModel) ResetLoginFailures(id int64) error {
+	query := `
+	UPDATE users
+	SET failed_logins = 0, last_failed_login = NULL, locked_until = NULL
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (u UserModel) ListLockouts(filters Filters) ([]*Lockout, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), id, username, email, failed_logins, last_failed_login, locked_until
+	FROM users
+	WHERE locked_until > now()
+	ORDER BY locked_until DESC
+	LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	lockouts := []*Lockout{}
+	for rows.Next() {
+		var lockout Lockout
+		err := rows.Scan(
+			&totalRecords,
+			&lockout.UserID,
+			&lockout.Username,
+			&lockout.Email,
+			&lockout.FailedLogins,
+			&lockout.LastFailedLogin,
+			&lockout.LockedUntil,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		lockouts = append(lockouts, &lockout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return lockouts, metadata, nil
+}
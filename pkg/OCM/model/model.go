@@ -13,6 +13,20 @@ type Models struct {
 	Verifications VerificationModel
 	Roles         RoleModel
 	Student       StudentModel
+	Revocations   RevocationModel
+	Bans          BanModel
+	Permissions   PermissionModel
+	SigningKeys   SigningKeyModel
+	APIKeys       APIKeyModel
+	Identities    IdentityModel
+	Sessions      SessionModel
+	Audit         AuditModel
+	Modules       ModuleModel
+	Lessons       LessonModel
+	Enrollments   EnrollmentModel
+	Prerequisites PrerequisiteModel
+	Categories    CategoryModel
+	Tags          TagModel
 }
 type StudentCourse struct {
 	StudentID int `json:"studentid"`
@@ -50,5 +64,47 @@ func NewModels(db *sql.DB) Models {
 		Student: StudentModel{
 			DB: db,
 		},
+		Revocations: RevocationModel{
+			DB: db,
+		},
+		Bans: BanModel{
+			DB: db,
+		},
+		Permissions: PermissionModel{
+			DB: db,
+		},
+		SigningKeys: SigningKeyModel{
+			DB: db,
+		},
+		APIKeys: APIKeyModel{
+			DB: db,
+		},
+		Identities: IdentityModel{
+			DB: db,
+		},
+		Sessions: SessionModel{
+			DB: db,
+		},
+		Audit: AuditModel{
+			DB: db,
+		},
+		Modules: ModuleModel{
+			DB: db,
+		},
+		Lessons: LessonModel{
+			DB: db,
+		},
+		Enrollments: EnrollmentModel{
+			DB: db,
+		},
+		Prerequisites: PrerequisiteModel{
+			DB: db,
+		},
+		Categories: CategoryModel{
+			DB: db,
+		},
+		Tags: TagModel{
+			DB: db,
+		},
 	}
 }
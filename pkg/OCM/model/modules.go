@@ -0,0 +1,352 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInvalidOrder is returned when a new order does not list every module
+// or lesson of its parent exactly once.
+var ErrInvalidOrder = errors.New("order must list every item exactly once")
+
+// Module is an ordered section of a course. Positions start at 1 and have
+// no gaps.
+type Module struct {
+	ID       int64  `json:"id"`
+	CourseID int    `json:"courseid"`
+	Title    string `json:"title"`
+	Position int    `json:"position"`
+}
+
+func ValidateModule(v *validator.Validator, module *Module) {
+	v.Check(module.Title != "", "title", "must be provided")
+	v.Check(len(module.Title) <= 255, "title", "must not be more than 255 bytes long")
+}
+
+type ModuleModel struct {
+	DB *sql.DB
+}
+
+// lockCourse locks the course row for the rest of the transaction, so that
+// concurrent changes to the positions of its modules are serialized.
+func lockCourse(ctx context.Context, tx *sql.Tx, courseID int) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM course WHERE courseid = $1 FOR UPDATE`, courseID)
+	return err
+}
+
+func (m ModuleModel) ListForCourse(courseID int) ([]*Module, error) {
+	query := `
+	SELECT id, course_id, title, position
+	FROM course_modules
+	WHERE course_id = $1
+	ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	modules := []*Module{}
+	for rows.Next() {
+		var module Module
+		err := rows.Scan(&module.ID, &module.CourseID, &module.Title, &module.Position)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, &module)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+func (m ModuleModel) Get(id int64) (*Module, error) {
+	query := `
+	SELECT id, course_id, title, position
+	FROM course_modules
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var module Module
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&module.ID, &module.CourseID, &module.Title, &module.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &module, nil
+}
+
+// Insert adds the module at the end of its course.
+func (m ModuleModel) Insert(module *Module, userID int64) error {
+	query := `
+	INSERT INTO course_modules (course_id, title, position)
+	SELECT $1::integer, $2, COALESCE(MAX(position), 0) + 1
+	FROM course_modules
+	WHERE course_id = $1
+	RETURNING id, position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, module.CourseID, userID)
+	if err != nil {
+		return err
+	}
+	err = lockCourse(ctx, tx, module.CourseID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, module.CourseID, module.Title).Scan(&module.ID, &module.Position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the title of the module. Positions are changed with Reorder.
+func (m ModuleModel) Update(module *Module, userID int64) error {
+	query := `
+	UPDATE course_modules
+	SET title = $1
+	WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := authorizeCourse(ctx, m.DB, module.CourseID, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.DB.ExecContext(ctx, query, module.Title, module.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete removes the module with all its lessons and closes the gap it
+// leaves in the order of the course.
+func (m ModuleModel) Delete(module *Module, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, module.CourseID, userID)
+	if err != nil {
+		return err
+	}
+	err = lockCourse(ctx, tx, module.CourseID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx, `DELETE FROM course_modules WHERE id = $1 RETURNING position`, module.ID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := `
+	UPDATE course_modules
+	SET position = position - 1
+	WHERE course_id = $1 AND position > $2`
+
+	_, err = tx.ExecContext(ctx, query, module.CourseID, position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reorder puts the modules of the course in the given order. ids must hold
+// every module of the course exactly once.
+func (m ModuleModel) Reorder(courseID int, ids []int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, userID)
+	if err != nil {
+		return err
+	}
+	err = lockCourse(ctx, tx, courseID)
+	if err != nil {
+		return err
+	}
+
+	err = reorder(ctx, tx, "course_modules", "course_id", courseID, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reorder sets the positions of the rows of table whose parentColumn is
+// parentID to the order of ids. The position constraints are deferred, so
+// the rows can swap places within the transaction.
+func reorder(ctx context.Context, tx *sql.Tx, table, parentColumn string, parentID interface{}, ids []int64) error {
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT count(*) FROM `+table+` WHERE `+parentColumn+` = $1`, parentID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(ids) {
+		return ErrInvalidOrder
+	}
+
+	query := `
+	UPDATE ` + table + ` t
+	SET position = o.position
+	FROM unnest($1::bigint[]) WITH ORDINALITY AS o(id, position)
+	WHERE t.id = o.id AND t.` + parentColumn + ` = $2`
+
+	result, err := tx.ExecContext(ctx, query, pq.Array(ids), parentID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// Duplicate or foreign IDs leave some rows of the parent untouched.
+	if rowsAffected != int64(len(ids)) {
+		return ErrInvalidOrder
+	}
+	return nil
+}
+
+// Outline is a course with all its modules and their lessons, in order.
+type Outline struct {
+	Course
+	Modules []*OutlineModule `json:"modules"`
+}
+
+type OutlineModule struct {
+	Module
+	Lessons []*Lesson `json:"lessons"`
+}
+
+// Outline returns the whole tree of the course in one query.
+func (cm *CourseModel) Outline(courseID int) (*Outline, error) {
+	query := `
+	SELECT c.courseid, c.title, c.description, c.courseduration, c.capacity,
+		c.start_date, c.end_date, c.weekly_hours, c.duration_weeks,
+		m.id, m.title, m.position,
+		l.id, l.title, l.body, l.position
+	FROM course c
+	LEFT JOIN course_modules m ON m.course_id = c.courseid
+	LEFT JOIN lessons l ON l.module_id = m.id
+	WHERE c.courseid = $1
+	ORDER BY m.position, l.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outline *Outline
+	var module *OutlineModule
+	for rows.Next() {
+		var course Course
+		var description, duration sql.NullString
+		var moduleID, lessonID sql.NullInt64
+		var moduleTitle, lessonTitle, lessonBody sql.NullString
+		var modulePosition, lessonPosition sql.NullInt32
+		err := rows.Scan(
+			&course.CourseId, &course.Title, &description, &duration, &course.Capacity,
+			&course.StartDate, &course.EndDate, &course.WeeklyHours, &course.DurationWeeks,
+			&moduleID, &moduleTitle, &modulePosition,
+			&lessonID, &lessonTitle, &lessonBody, &lessonPosition,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if outline == nil {
+			course.Description = description.String
+			course.CourseDuration = duration.String
+			outline = &Outline{Course: course, Modules: []*OutlineModule{}}
+		}
+		if !moduleID.Valid {
+			continue
+		}
+		if module == nil || module.ID != moduleID.Int64 {
+			module = &OutlineModule{
+				Module: Module{
+					ID:       moduleID.Int64,
+					CourseID: course.CourseId,
+					Title:    moduleTitle.String,
+					Position: int(modulePosition.Int32),
+				},
+				Lessons: []*Lesson{},
+			}
+			outline.Modules = append(outline.Modules, module)
+		}
+		if lessonID.Valid {
+			module.Lessons = append(module.Lessons, &Lesson{
+				ID:       lessonID.Int64,
+				ModuleID: module.ID,
+				Title:    lessonTitle.String,
+				Body:     lessonBody.String,
+				Position: int(lessonPosition.Int32),
+			})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if outline == nil {
+		return nil, ErrRecordNotFound
+	}
+	return outline, nil
+}
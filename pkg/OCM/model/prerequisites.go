@@ -0,0 +1,228 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPrerequisiteCycle    = errors.New("prerequisite would create a cycle")
+	ErrPrerequisiteNotFound = errors.New("prerequisite course does not exist")
+	ErrPrerequisitesNotMet  = errors.New("student has not completed the prerequisites")
+)
+
+// Prerequisite is a course that has to be completed before another one.
+// Depth is 1 for the direct prerequisites of the course, 2 for theirs and
+// so on; a course reachable in several ways gets the shortest.
+type Prerequisite struct {
+	Course
+	Depth int `json:"depth"`
+}
+
+type PrerequisiteModel struct {
+	DB *sql.DB
+}
+
+// List returns every course that comes before the course, directly or
+// through other prerequisites, nearest first.
+func (m PrerequisiteModel) List(courseID int) ([]*Prerequisite, error) {
+	// The graph is walked breadth first, one row per depth. Courses already
+	// visited are not expanded again, so each course is reached once, at its
+	// shortest depth, however many paths lead to it.
+	query := `
+	WITH RECURSIVE levels (depth, frontier, visited) AS (
+		SELECT 1, array_agg(DISTINCT prerequisite_id), array_agg(DISTINCT prerequisite_id)
+		FROM course_prerequisites
+		WHERE course_id = $1
+		UNION
+		SELECT l.depth + 1, n.ids, l.visited || n.ids
+		FROM levels l
+		CROSS JOIN LATERAL (
+			SELECT array_agg(DISTINCT p.prerequisite_id) AS ids
+			FROM course_prerequisites p
+			WHERE p.course_id = ANY (l.frontier)
+			AND p.prerequisite_id <> ALL (l.visited)
+		) n
+		WHERE n.ids IS NOT NULL
+	)
+	SELECT ` + courseColumns("c") + `, l.depth
+	FROM levels l
+	CROSS JOIN LATERAL unnest(l.frontier) AS f (courseid)
+	JOIN course c ON c.courseid = f.courseid
+	ORDER BY l.depth, c.courseid`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prerequisites := []*Prerequisite{}
+	for rows.Next() {
+		var prerequisite Prerequisite
+		err := rows.Scan(append(prerequisite.fields(), &prerequisite.Depth)...)
+		if err != nil {
+			return nil, err
+		}
+		prerequisites = append(prerequisites, &prerequisite)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return prerequisites, nil
+}
+
+// Add makes prerequisiteID a direct prerequisite of the course. It returns
+// ErrPrerequisiteCycle if the course already comes before prerequisiteID,
+// or is the same course. Adding an existing prerequisite is not an error.
+func (m PrerequisiteModel) Add(courseID, prerequisiteID int, userID int64) error {
+	query := `
+	WITH RECURSIVE closure (courseid) AS (
+		SELECT $1::integer
+		UNION
+		SELECT p.prerequisite_id
+		FROM closure cl
+		JOIN course_prerequisites p ON p.course_id = cl.courseid
+	)
+	SELECT EXISTS(SELECT 1 FROM closure WHERE courseid = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, userID)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM course WHERE courseid = $1)`, prerequisiteID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPrerequisiteNotFound
+	}
+
+	// Two edges that are fine on their own can make a cycle together, so
+	// only one transaction at a time may add any.
+	_, err = tx.ExecContext(ctx, `LOCK TABLE course_prerequisites IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	var cycle bool
+	err = tx.QueryRowContext(ctx, query, prerequisiteID, courseID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrPrerequisiteCycle
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO course_prerequisites (course_id, prerequisite_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`, courseID, prerequisiteID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Remove removes a direct prerequisite of the course. It returns
+// ErrRecordNotFound if there is no such prerequisite.
+func (m PrerequisiteModel) Remove(courseID, prerequisiteID int, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM course_prerequisites WHERE course_id = $1 AND prerequisite_id = $2`, courseID, prerequisiteID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// missingPrerequisites returns the titles of the direct prerequisites of
+// the course that the student has not completed. Only direct ones count:
+// a student let into a course without its prerequisites, and who then
+// completed it, should not be held back by them further down the chain.
+func missingPrerequisites(ctx context.Context, tx *sql.Tx, courseID, studentID int) ([]string, error) {
+	query := `
+	SELECT c.title
+	FROM course_prerequisites p
+	JOIN course c ON c.courseid = p.prerequisite_id
+	WHERE p.course_id = $1 AND NOT EXISTS (
+		SELECT 1 FROM student_course sc
+		WHERE sc.courseid = p.prerequisite_id AND sc.studentid = $2 AND sc.status = 'completed'
+	)
+	ORDER BY c.title`
+
+	rows, err := tx.QueryContext(ctx, query, courseID, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// checkPrerequisites returns ErrPrerequisitesNotMet, naming the missing
+// courses, unless the student has completed the prerequisites of the
+// course. With override the student is let in anyway and the override is
+// written to the audit log.
+func checkPrerequisites(ctx context.Context, tx *sql.Tx, courseID, studentID int, override bool, actor Actor) error {
+	missing, err := missingPrerequisites(ctx, tx, courseID, studentID)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	if !override {
+		return fmt.Errorf("student %d: %w (%s)", studentID, ErrPrerequisitesNotMet, strings.Join(missing, ", "))
+	}
+
+	after := map[string]interface{}{"student_id": studentID, "missing_prerequisites": missing}
+	return writeAudit(ctx, tx, actor, AuditPrerequisiteOverride, "course", courseID, nil, after)
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// Revoke stores the jti of an access token until the token would have
+// expired anyway. Entries that are past their expiry are pruned on the way.
+func (m RevocationModel) Revoke(jti string, expiry time.Time) error {
+	query := `
+	INSERT INTO revoked_tokens (jti, expiry)
+	VALUES ($1, $2)
+	ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, jti, expiry)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM revoked_tokens
+	WHERE expiry < $1`
+	_, err = m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
+
+func (m RevocationModel) IsRevoked(jti string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revoked bool
+	err := m.DB.QueryRowContext(ctx, query, jti).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
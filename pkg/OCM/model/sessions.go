@@ -0,0 +1,228 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is one login on one device. Its refresh token ID changes with
+// every refresh, so only the latest refresh token of a session is valid.
+// The ID and expiry of the latest access token are kept so that it can be
+// revoked together with the session.
+type Session struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"-"`
+	UserAgent      string    `json:"user_agent"`
+	IP             string    `json:"ip"`
+	MFA            bool      `json:"two_factor"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastUsedAt     time.Time `json:"last_used_at"`
+	Expiry         time.Time `json:"expiry"`
+	RefreshTokenID string    `json:"-"`
+	AccessTokenID  string    `json:"-"`
+	AccessExpiry   time.Time `json:"-"`
+	Current        bool      `json:"current"`
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the session record and logs the login. It has no tokens
+// yet; those are recorded with Update once they have been signed.
+func (m SessionModel) Insert(session *Session, actor Actor) error {
+	query := `
+	INSERT INTO sessions (user_id, user_agent, ip, mfa, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{session.UserID, session.UserAgent, session.IP, session.MFA, session.Expiry}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		return err
+	}
+
+	after := map[string]interface{}{"session_id": session.ID, "user_agent": session.UserAgent, "two_factor": session.MFA}
+	err = writeAudit(ctx, tx, actor, AuditLogin, "user", session.UserID, nil, after)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns an unexpired session of the user.
+func (m SessionModel) Get(userID, id int64) (*Session, error) {
+	query := `
+	SELECT id, user_id, user_agent, ip, mfa, created_at, last_used_at, expiry, refresh_token_id, access_token_id, access_expiry
+	FROM sessions
+	WHERE id = $1 AND user_id = $2 AND expiry > now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Session
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.MFA, &s.CreatedAt, &s.LastUsedAt, &s.Expiry,
+		&s.RefreshTokenID, &s.AccessTokenID, &s.AccessExpiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func (m SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `
+	SELECT id, user_id, user_agent, ip, mfa, created_at, last_used_at, expiry
+	FROM sessions
+	WHERE user_id = $1 AND expiry > now()
+	ORDER BY last_used_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var s Session
+		err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.MFA, &s.CreatedAt, &s.LastUsedAt, &s.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Update records newly issued tokens. It only succeeds if the session still
+// holds previousRefreshID, so two refreshes with the same token can't both
+// win; the loser gets ErrEditConflict. The access token issued before is
+// revoked.
+func (m SessionModel) Update(session *Session, previousRefreshID string) error {
+	query := `
+	WITH previous AS (
+		SELECT access_token_id, access_expiry
+		FROM sessions
+		WHERE id = $1 AND refresh_token_id = $2
+		FOR UPDATE
+	), revoked AS (
+		INSERT INTO revoked_tokens (jti, expiry)
+		SELECT access_token_id, access_expiry FROM previous
+		WHERE access_token_id <> '' AND access_expiry > now()
+		ON CONFLICT (jti) DO NOTHING
+	)
+	UPDATE sessions
+	SET refresh_token_id = $3, access_token_id = $4, access_expiry = $5, expiry = $6, last_used_at = now()
+	WHERE id = $1 AND refresh_token_id = $2 AND expiry > now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{session.ID, previousRefreshID, session.RefreshTokenID, session.AccessTokenID, session.AccessExpiry, session.Expiry}
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Touch updates the last used time, at most every few minutes.
+func (m SessionModel) Touch(id int64) error {
+	query := `
+	UPDATE sessions
+	SET last_used_at = now()
+	WHERE id = $1 AND last_used_at < now() - interval '5 minutes'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Delete ends one of the user's sessions and revokes its access token.
+func (m SessionModel) Delete(userID, id int64) error {
+	query := `
+	WITH deleted AS (
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2
+		RETURNING access_token_id, access_expiry
+	), revoked AS (
+		INSERT INTO revoked_tokens (jti, expiry)
+		SELECT access_token_id, access_expiry FROM deleted
+		WHERE access_token_id <> '' AND access_expiry > now()
+		ON CONFLICT (jti) DO NOTHING
+	)
+	SELECT count(*) FROM deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deleted int
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&deleted)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser ends every session of the user and revokes their access
+// tokens. Expired sessions of all users are cleaned up on the way.
+func (m SessionModel) DeleteAllForUser(userID int64) error {
+	return m.DeleteAllForUserExcept(userID, 0)
+}
+
+// DeleteAllForUserExcept is DeleteAllForUser but keeps the session with
+// the given ID.
+func (m SessionModel) DeleteAllForUserExcept(userID, sessionID int64) error {
+	query := `
+	WITH deleted AS (
+		DELETE FROM sessions
+		WHERE (user_id = $1 AND id <> $2) OR expiry < now()
+		RETURNING access_token_id, access_expiry
+	)
+	INSERT INTO revoked_tokens (jti, expiry)
+	SELECT access_token_id, access_expiry FROM deleted
+	WHERE access_token_id <> '' AND access_expiry > now()
+	ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, sessionID)
+	return err
+}
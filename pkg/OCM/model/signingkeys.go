@@ -0,0 +1,115 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SigningKey is a key pair used to sign tokens. PrivateKey is stored
+// encrypted; the model never sees it in the clear. A key that has been
+// retired no longer signs anything, but tokens it signed stay valid until
+// Expiry.
+type SigningKey struct {
+	ID         string
+	PrivateKey []byte
+	PublicKey  []byte
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+	Expiry     *time.Time
+}
+
+type SigningKeyModel struct {
+	DB *sql.DB
+}
+
+// List returns all keys that can still verify tokens, newest first.
+func (m SigningKeyModel) List() ([]*SigningKey, error) {
+	query := `
+	SELECT kid, private_key, public_key, created_at, retired_at, expiry
+	FROM signing_keys
+	WHERE expiry IS NULL OR expiry > now()
+	ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(&key.ID, &key.PrivateKey, &key.PublicKey, &key.CreatedAt, &key.RetiredAt, &key.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rotate adds key and schedules all other keys to retire at retireAt, after
+// which they expire at retiredExpiry. If another key was added less than
+// minAge ago nothing is changed and false is returned, so several instances
+// rotating on the same schedule only rotate once.
+func (m SigningKeyModel) Rotate(key *SigningKey, retireAt, retiredExpiry time.Time, minAge time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return false, err
+	}
+
+	var recent bool
+	query := `
+	SELECT EXISTS(
+		SELECT 1 FROM signing_keys
+		WHERE retired_at IS NULL AND created_at > $1
+	)`
+	err = tx.QueryRowContext(ctx, query, time.Now().Add(-minAge)).Scan(&recent)
+	if err != nil {
+		return false, err
+	}
+	if recent {
+		return false, nil
+	}
+
+	query = `
+	UPDATE signing_keys
+	SET retired_at = $1, expiry = $2
+	WHERE retired_at IS NULL`
+	_, err = tx.ExecContext(ctx, query, retireAt, retiredExpiry)
+	if err != nil {
+		return false, err
+	}
+
+	query = `
+	INSERT INTO signing_keys (kid, private_key, public_key)
+	VALUES ($1, $2, $3)
+	RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, key.ID, key.PrivateKey, key.PublicKey).Scan(&key.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM signing_keys WHERE expiry < now()`)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -2,11 +2,14 @@ package model
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"log"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Student struct {
@@ -14,8 +17,13 @@ type Student struct {
 	Name      string  `json:"name"`
 	Age       int     `json:"age"`
 	GPA       float64 `json:"gpa"`
+	UserID    *int64  `json:"user_id,omitempty"`
 }
 
+// ErrStudentLinked is returned when the user is already linked to another
+// student, or the student to another user.
+var ErrStudentLinked = errors.New("user or student is already linked")
+
 var students = []Student{
 	{
 		StudentID: 1,
@@ -72,7 +80,7 @@ func (sm *StudentModel) List(page, pageSize int, filter, sort string) ([]*Studen
 
 func (sm *StudentModel) Get(id int) (*Student, error) {
 	query := `
-        SELECT studentid, name, age, gpa
+        SELECT studentid, name, age, gpa, user_id
         FROM student
         WHERE studentid = $1
     `
@@ -80,7 +88,7 @@ func (sm *StudentModel) Get(id int) (*Student, error) {
 	defer cancel()
 
 	student := &Student{}
-	err := sm.DB.QueryRowContext(ctx, query, id).Scan(&student.StudentID, &student.Name, &student.Age, &student.GPA)
+	err := sm.DB.QueryRowContext(ctx, query, id).Scan(&student.StudentID, &student.Name, &student.Age, &student.GPA, &student.UserID)
 	if err != nil { // nil => null
 		if err == sql.ErrNoRows {
 			return nil, errors.New("students not found")
@@ -121,24 +129,40 @@ func (sm *StudentModel) Update(student *Student) error {
 	return sm.DB.QueryRowContext(ctx, query, args...).Scan(&student.StudentID)
 }
 
-func (sm *StudentModel) Delete(id int) error {
+func (sm *StudentModel) Delete(id int, actor Actor) error {
 
 	query := `
         DELETE FROM student
         WHERE studentid = $1
+        RETURNING studentid, name, age, gpa, user_id
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := sm.DB.ExecContext(ctx, query, id)
-	return err
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var student Student
+	err = tx.QueryRowContext(ctx, query, id).Scan(&student.StudentID, &student.Name, &student.Age, &student.GPA, &student.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = writeAudit(ctx, tx, actor, AuditStudentDelete, "student", id, student, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 func (sm *StudentModel) FetchStudentsByCourse(courseID int) ([]Student, error) {
 	stmt := `SELECT s.studentid, s.name, s.age, s.gpa
 			 FROM student s
 			 JOIN student_course sc ON s.studentid = sc.studentid
 			 JOIN course c ON sc.courseid = c.courseid
-			 WHERE c.courseid = $1`
+			 WHERE c.courseid = $1 AND sc.status IN ('active', 'completed')`
 
 	rows, err := sm.DB.Query(stmt, courseID)
 	if err != nil {
@@ -161,3 +185,181 @@ func (sm *StudentModel) FetchStudentsByCourse(courseID int) ([]Student, error) {
 	}
 	return students, nil
 }
+
+// GetForUser returns the student record linked to the user.
+func (sm *StudentModel) GetForUser(userID int64) (*Student, error) {
+	query := `
+        SELECT studentid, name, age, gpa, user_id
+        FROM student
+        WHERE user_id = $1
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	student := &Student{}
+	err := sm.DB.QueryRowContext(ctx, query, userID).Scan(&student.StudentID, &student.Name, &student.Age, &student.GPA, &student.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return student, nil
+}
+
+// Link makes userID the account of the student. It returns
+// ErrStudentLinked if the user already belongs to another student or the
+// student to another user.
+func (sm *StudentModel) Link(studentID int, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return linkStudent(ctx, sm.DB, studentID, userID)
+}
+
+// Unlink removes the account link of the student.
+func (sm *StudentModel) Unlink(studentID int) error {
+	query := `
+        UPDATE student
+        SET user_id = NULL
+        WHERE studentid = $1
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := sm.DB.ExecContext(ctx, query, studentID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// StudentInvitation lets whoever holds the code and an account with the
+// invited email address link that account to the student.
+type StudentInvitation struct {
+	Code      []byte    `json:"-"`
+	PlainText string    `json:"-"`
+	StudentID int       `json:"studentid"`
+	Email     string    `json:"email"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// Invite issues an invitation for the student. Earlier invitations for the
+// same student stop working.
+func (sm *StudentModel) Invite(studentID int, email string, ttl time.Duration) (*StudentInvitation, error) {
+	verification, err := generateVerificationCode(0, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+	invitation := &StudentInvitation{
+		Code:      verification.Code,
+		PlainText: verification.PlainText,
+		StudentID: studentID,
+		Email:     email,
+		Expiry:    verification.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM student_invitations WHERE student_id = $1 OR expiry < now()`, studentID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        INSERT INTO student_invitations (code, student_id, email, expiry)
+        VALUES ($1, $2, $3, $4)
+    `
+	_, err = tx.ExecContext(ctx, query, invitation.Code, invitation.StudentID, invitation.Email, invitation.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, tx.Commit()
+}
+
+// AcceptInvitation links the user to the student the invitation was issued
+// for. The invitation must be unexpired and addressed to the user's email.
+// It returns ErrRecordNotFound if there is no such invitation.
+func (sm *StudentModel) AcceptInvitation(plaintext string, user *User) (int, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        DELETE FROM student_invitations
+        WHERE code = $1 AND lower(email) = lower($2) AND expiry > now()
+        RETURNING student_id
+    `
+	var studentID int
+	err = tx.QueryRowContext(ctx, query, hash[:], user.Email).Scan(&studentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	err = linkStudent(ctx, tx, studentID, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	return studentID, tx.Commit()
+}
+
+// linkStudent links the student to the user. A student already linked to
+// someone else is left alone and ErrStudentLinked returned; they have to be
+// unlinked first.
+func linkStudent(ctx context.Context, db rowQuerier, studentID int, userID int64) error {
+	query := `
+        WITH linked AS (
+            UPDATE student
+            SET user_id = $2
+            WHERE studentid = $1 AND (user_id IS NULL OR user_id = $2)
+            RETURNING studentid
+        )
+        SELECT EXISTS(SELECT 1 FROM student WHERE studentid = $1), EXISTS(SELECT 1 FROM linked)
+    `
+	var exists, linked bool
+	err := db.QueryRowContext(ctx, query, studentID, userID).Scan(&exists, &linked)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "student_user_id_key":
+			return ErrStudentLinked
+		default:
+			return err
+		}
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+	if !linked {
+		return ErrStudentLinked
+	}
+	return nil
+}
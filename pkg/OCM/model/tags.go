@@ -0,0 +1,94 @@
+package model
+
+import (
+	"OCM/pkg/OCM/validator"
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTagsPerCourse is how many tags one course can have.
+const maxTagsPerCourse = 20
+
+// NormalizeTags trims and lower-cases the tags and drops empty and
+// repeated ones, so that "Go" and " go" are the same tag.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= maxTagsPerCourse, "tags", "must not contain more than "+strconv.Itoa(maxTagsPerCourse)+" tags")
+	for _, tag := range tags {
+		v.Check(len(tag) <= 50, "tags", "must not contain tags more than 50 bytes long")
+	}
+}
+
+// TagModel manages the free-form tags of courses. Tags only exist through
+// the courses that have them.
+type TagModel struct {
+	DB *sql.DB
+}
+
+func (m TagModel) ListForCourse(courseID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tags := []string{}
+	err := m.DB.QueryRowContext(ctx, `
+	SELECT COALESCE(array_agg(tag ORDER BY tag), '{}')
+	FROM course_tags
+	WHERE course_id = $1`, courseID).Scan(pq.Array(&tags))
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// SetForCourse replaces the tags of the course with the given ones, which
+// should have gone through NormalizeTags.
+func (m TagModel) SetForCourse(courseID int, tags []string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = authorizeCourse(ctx, tx, courseID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM course_tags WHERE course_id = $1`, courseID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO course_tags (course_id, tag)
+	SELECT $1::integer, unnest($2::text[])
+	ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, courseID, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,381 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SecretSealer encrypts secrets that have to be read back, such as TOTP
+// secrets, before they are stored.
+type SecretSealer interface {
+	SealSecret(plaintext []byte) ([]byte, error)
+	OpenSecret(sealed []byte) ([]byte, error)
+}
+
+var secretSealer SecretSealer
+
+// SetSecretSealer sets how secrets are encrypted at rest. It is meant to be
+// called once at startup, before any secret is read or written.
+func SetSecretSealer(s SecretSealer) {
+	secretSealer = s
+}
+
+var errNoSecretSealer = errors.New("no secret sealer has been set")
+
+// TwoFactor is the TOTP state of a user. Secret is set as soon as enrollment
+// starts, but only counts once Enabled is true. It is stored sealed.
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+func (u UserModel) GetTwoFactor(userID int64) (*TwoFactor, error) {
+	query := `
+	SELECT totp_secret_sealed, totp_enabled, totp_last_step
+	FROM users
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tf TwoFactor
+	var sealed []byte
+	err := u.DB.QueryRowContext(ctx, query, userID).Scan(&sealed, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if sealed != nil {
+		if secretSealer == nil {
+			return nil, errNoSecretSealer
+		}
+		secret, err := secretSealer.OpenSecret(sealed)
+		if err != nil {
+			return nil, err
+		}
+		tf.Secret = string(secret)
+	}
+	return &tf, nil
+}
+
+// SetTOTPSecret stores a pending secret. It is refused once 2FA is enabled
+// so that a stolen access token can't silently re-enroll the account.
+func (u UserModel) SetTOTPSecret(userID int64, secret string) error {
+	query := `
+	UPDATE users
+	SET totp_secret_sealed = $1, totp_secret = NULL, totp_last_step = 0
+	WHERE id = $2 AND NOT totp_enabled`
+
+	if secretSealer == nil {
+		return errNoSecretSealer
+	}
+	sealed, err := secretSealer.SealSecret([]byte(secret))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, sealed, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// EnableTwoFactor turns on 2FA for the pending secret and replaces the
+// user's recovery codes in the same transaction. step is the time step of
+// the code that confirmed enrollment.
+func (u UserModel) EnableTwoFactor(userID int64, step int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET totp_enabled = true, totp_last_step = $1
+	WHERE id = $2 AND totp_secret_sealed IS NOT NULL AND NOT totp_enabled`
+
+	result, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTwoFactor removes the secret and all recovery codes.
+func (u UserModel) DisableTwoFactor(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE users
+	SET totp_secret = NULL, totp_secret_sealed = NULL, totp_enabled = false, totp_last_step = 0
+	WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SealTOTPSecrets seals the TOTP secrets that earlier versions stored in
+// plaintext and returns how many there were.
+func (u UserModel) SealTOTPSecrets() (int, error) {
+	if secretSealer == nil {
+		return 0, errNoSecretSealer
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, `SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	secrets := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var secret string
+		if err := rows.Scan(&id, &secret); err != nil {
+			return 0, err
+		}
+		secrets[id] = secret
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	query := `
+	UPDATE users
+	SET totp_secret_sealed = $1, totp_secret = NULL
+	WHERE id = $2 AND totp_secret = $3`
+
+	for id, secret := range secrets {
+		sealed, err := secretSealer.SealSecret([]byte(secret))
+		if err != nil {
+			return 0, err
+		}
+		_, err = u.DB.ExecContext(ctx, query, sealed, id, secret)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(secrets), nil
+}
+
+// UseTOTPStep records that the code for step has been used. It returns
+// false if that step, or a later one, was already used, which means the
+// code is being replayed.
+func (u UserModel) UseTOTPStep(userID int64, step int64) (bool, error) {
+	query := `
+	UPDATE users
+	SET totp_last_step = $1
+	WHERE id = $2 AND totp_last_step < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// ReplaceRecoveryCodes throws away the user's recovery codes and returns a
+// fresh set. The plaintext codes are only ever available here.
+func (u UserModel) ReplaceRecoveryCodes(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode marks a matching unused recovery code as used. It returns
+// false if there is no such code.
+func (u UserModel) UseRecoveryCode(userID int64, plaintext string) (bool, error) {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(plaintext)))
+
+	query := `
+	UPDATE recovery_codes
+	SET used_at = now()
+	WHERE id = (
+		SELECT id FROM recovery_codes
+		WHERE user_id = $1 AND code = $2 AND used_at IS NULL
+		LIMIT 1
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with or without the dash and in
+// any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// SetRequire2FA controls whether holders of the role must sign in with a
+// second factor.
+func (db *RoleModel) SetRequire2FA(role string, required bool, actor Actor) error {
+	query := `
+	UPDATE roles
+	SET require_2fa = $1
+	FROM (SELECT id, require_2fa FROM roles WHERE name = $2 FOR UPDATE) previous
+	WHERE roles.id = previous.id
+	RETURNING previous.require_2fa`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous bool
+	err = tx.QueryRowContext(ctx, query, required, role).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if previous != required {
+		err = writeAudit(ctx, tx, actor, AuditRoleTwoFactor, "role", role,
+			map[string]bool{"require_2fa": previous}, map[string]bool{"require_2fa": required})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RequiresTwoFactor reports whether any of the user's roles requires 2FA.
+func (db *RoleModel) RequiresTwoFactor(userID int64) (bool, error) {
+	query := `
+	SELECT EXISTS(
+		SELECT 1
+		FROM user_roles
+		INNER JOIN roles ON roles.id = user_roles.role_id
+		WHERE user_roles.user_id = $1 AND roles.require_2fa
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var required bool
+	err := db.DB.QueryRowContext(ctx, query, userID).Scan(&required)
+	return required, err
+}
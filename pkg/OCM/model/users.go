@@ -6,10 +6,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 type password struct {
@@ -24,7 +25,19 @@ type User struct {
 	Password  password `json:"-"`
 	TokenHash string   `json:"-"`
 	Activated bool     `json:"-"`
-	Role      string   `json:"-"`
+}
+
+// UserDetails is the admin view of an account, including its role, ban and
+// activation state.
+type UserDetails struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	Activated   bool       `json:"activated"`
+	Roles       Roles      `json:"roles"`
+	Banned      bool       `json:"banned"`
+	BanExpiry   *time.Time `json:"ban_expiry,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
 }
 
 var (
@@ -44,75 +57,45 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-func (u UserModel) IsAdmin(id int64) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM admins WHERE user_id = $1) AS isadmin`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	var isAdmin bool
-	err := u.DB.QueryRowContext(ctx, query, id).Scan(&isAdmin)
-	if err != nil {
-		return false, err
-	}
-	return isAdmin, nil
-}
-
+// HasBan reports whether the user has a ban that has not expired. Expired
+// bans are kept for the record.
 func (u UserModel) HasBan(id int64) (bool, error) {
 	query := `
-	select bans.id
-	from bans
-	inner join users on users.id=bans.user_id
-	where bans.user_id = $1
-	and bans.expiry > $2`
+	SELECT EXISTS(
+		SELECT 1 FROM bans
+		WHERE bans.user_id = $1 AND bans.expiry > now()
+	)`
 
-	var ban_id int64
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := u.DB.QueryRowContext(ctx, query, id, time.Now()).Scan(
-		&ban_id,
-	)
 
-	query = `
-	delete from bans
-       where expiry<$1`
-	_, _ = u.DB.Exec(query, time.Now())
+	var banned bool
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(&banned)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-	return true, nil
-}
-
-func (u UserModel) GetRole(id int64) (string, error) {
-	isadmin, _ := u.IsAdmin(id)
-	if isadmin {
-		return "admin", nil
-	}
-
-	ban, _ := u.HasBan(id)
-	if ban {
-		return "banned_user", nil
+		return false, err
 	}
-	return "user", nil
+	return banned, nil
 }
 
-func (u UserModel) Insert(user *User) error {
+// Insert creates the user with DefaultRole, both or neither. The grant is
+// audited as done by the new user, from the actor's address.
+func (u UserModel) Insert(user *User, actor Actor) error {
 	query := `
-	INSERT INTO users (username, email, password, token_hash, role)
-	VALUES ($1, $2, $3, $4, 'user')
+	INSERT INTO users (username, email, password, token_hash)
+	VALUES ($1, $2, $3, $4)
 	RETURNING id`
 	args := []interface{}{user.Username, user.Email, user.Password.hash, user.TokenHash}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID)
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
@@ -123,16 +106,23 @@ func (u UserModel) Insert(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	actor.UserID = user.ID
+	granted, err := grantRole(ctx, tx, user.ID, DefaultRole, actor)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return fmt.Errorf("default role %q does not exist", DefaultRole)
+	}
+	return tx.Commit()
 }
 
 func (m UserModel) GetByUsername(username string) (*User, error) {
 	query := `
-	select users.id, username, email,password, activated, count(rating) as reviews
-	from users left join reviews
-	on reviews.user_id=users.id
-	WHERE username = lower($1)
-	group by users.id;`
+	SELECT id, username, email, password, activated, token_hash
+	FROM users
+	WHERE username = lower($1)`
 	var user User
 	err := m.DB.QueryRow(query, username).Scan(
 		&user.ID,
@@ -140,6 +130,32 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TokenHash,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) GetByID(id int64) (*User, error) {
+	query := `
+	SELECT id, username, email, password, activated, token_hash
+	FROM users
+	WHERE id = $1`
+	var user User
+	err := m.DB.QueryRow(query, id).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.TokenHash,
 	)
 	if err != nil {
 		switch {
@@ -154,7 +170,7 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, username, email, password, activated
+	SELECT id, username, email, password, activated, token_hash
 	FROM users
 	WHERE email = $1`
 	var user User
@@ -164,6 +180,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TokenHash,
 	)
 	if err != nil {
 		switch {
@@ -176,19 +193,19 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (u UserModel) GetByVerificationCode(plaintext string) (*User, error) {
+func (u UserModel) GetByVerificationCode(scope, plaintext string) (*User, error) {
 	hash := sha256.Sum256([]byte(plaintext))
-	log.Printf("Debug: Hash generated for plaintext '%s': %x", plaintext, hash)
 
 	query := `
     SELECT users.id, users.username, users.email, users.password, users.activated, users.token_hash
     FROM users
     INNER JOIN verifications
     ON users.id = verifications.user_id
-    WHERE verifications.code = $1`
+    WHERE verifications.code = $1
+    AND verifications.scope = $2
+    AND verifications.expiry > $3`
 
-	args := []interface{}{hash[:]}
-	log.Printf("Debug: Query args set: %x", plaintext)
+	args := []interface{}{hash[:], scope, time.Now()}
 
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -207,14 +224,12 @@ func (u UserModel) GetByVerificationCode(plaintext string) (*User, error) {
 		log.Printf("Error: QueryRowContext failed with %v", err)
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			log.Printf("Debug: No rows found for given hash")
 			return nil, ErrRecordNotFound
 		default:
 			return nil, err
 		}
 	}
 
-	log.Printf("Debug: User retrieved with ID %d", user.ID)
 	return &user, nil
 }
 
@@ -222,7 +237,7 @@ func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
 	SET username = $1, email = $2, password = $3, activated = $4, token_hash=$5
-	WHERE id = $6 
+	WHERE id = $6
 	RETURNING id`
 	args := []interface{}{
 		user.Username,
@@ -232,18 +247,35 @@ func (m UserModel) Update(user *User) error {
 		user.TokenHash,
 		user.ID,
 	}
-	err := m.DB.QueryRow(query, args...).Scan()
-	if errors.Is(err, sql.ErrNoRows) {
-		return ErrEditConflict
+	err := m.DB.QueryRow(query, args...).Scan(&user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
 	}
 	return nil
 }
 
-func (u UserModel) Delete(username string) error {
+// RotateTokenHash replaces the user's token hash, invalidating every refresh
+// token signed with the old one. It returns ErrEditConflict if the hash was
+// already rotated by someone else in the meantime.
+func (m UserModel) RotateTokenHash(user *User, tokenHash string) error {
 	query := `
-		DELETE FROM users
-		WHERE username = $1`
-	result, err := u.DB.Exec(query, username)
+	UPDATE users
+	SET token_hash = $1
+	WHERE id = $2 AND token_hash = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash, user.ID, user.TokenHash)
 	if err != nil {
 		return err
 	}
@@ -252,31 +284,105 @@ func (u UserModel) Delete(username string) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
+	user.TokenHash = tokenHash
 	return nil
 }
 
-func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+// RehashPassword hashes the user's password again with the current scheme
+// if the stored hash is out of date. plaintext must already have been
+// checked against the stored hash. The hash is only replaced if it has not
+// been changed in the meantime, in which case ErrEditConflict is returned.
+func (m UserModel) RehashPassword(user *User, plaintext string) error {
+	if !user.Password.NeedsRehash() {
+		return nil
+	}
+	oldHash := user.Password.hash
+
+	var newPassword password
+	err := newPassword.Set(plaintext)
 	if err != nil {
 		return err
 	}
-	p.plaintext = &plaintextPassword
-	p.hash = hash
+
+	query := `
+	UPDATE users
+	SET password = $1
+	WHERE id = $2 AND password = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, newPassword.hash, user.ID, oldHash)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	user.Password = newPassword
 	return nil
 }
-func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+
+func (u UserModel) Delete(username string, actor Actor) error {
+	query := `
+		DELETE FROM users
+		WHERE username = $1
+		RETURNING id, username, email, activated`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var details UserDetails
+	err = tx.QueryRowContext(ctx, query, username).Scan(&details.ID, &details.Username, &details.Email, &details.Activated)
 	if err != nil {
 		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
 		default:
-			return false, err
+			return err
 		}
 	}
-	return true, nil
+
+	before := map[string]interface{}{"username": details.Username, "email": details.Email, "activated": details.Activated}
+	err = writeAudit(ctx, tx, actor, AuditUserDelete, "user", details.ID, before, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := passwordHasher.Hash(plaintextPassword)
+	if err != nil {
+		return err
+	}
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+	return nil
+}
+
+// Matches checks the password against the stored hash, whichever of the
+// supported schemes made it.
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	return verifyPasswordHash(p.hash, plaintextPassword)
+}
+
+// NeedsRehash reports whether the stored hash was made with another scheme
+// or other parameters than the ones new passwords are hashed with.
+func (p *password) NeedsRehash() bool {
+	return passwordHasher.NeedsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -288,9 +394,16 @@ func ValidateUsername(v *validator.Validator, username string) {
 	v.Check(validator.Matches(username, validator.UsernameRX), "username", "must be a valid username")
 }
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	ValidatePasswordField(v, "password", password)
+}
+
+// ValidatePasswordField is ValidatePasswordPlaintext for a password sent in
+// a field with another name.
+func ValidatePasswordField(v *validator.Validator, key, password string) {
+	v.Check(password != "", key, "must be provided")
+	v.Check(len(password) >= 8, key, "must be at least 8 bytes long")
+	maxLength := passwordHasher.MaxLength()
+	v.Check(len(password) <= maxLength, key, fmt.Sprintf("must not be more than %d bytes long", maxLength))
 }
 
 func ValidateEmailOrUsername(v *validator.Validator, username string, email string) {
@@ -332,3 +445,139 @@ func (u UserModel) ActivateUser(userID int64) error {
 
 	return nil
 }
+
+const userDetailsColumns = `
+	users.id, users.username, users.email, users.activated,
+	ARRAY(
+		SELECT roles.name FROM roles
+		INNER JOIN user_roles ON user_roles.role_id = roles.id
+		WHERE user_roles.user_id = users.id
+		ORDER BY roles.name
+	),
+	bans.expiry,
+	CASE WHEN users.locked_until > now() THEN users.locked_until END`
+
+// scanUserDetails scans a row selected with userDetailsColumns. Any extra
+// destinations are filled from the columns preceding them.
+func scanUserDetails(row interface{ Scan(...interface{}) error }, details *UserDetails, extra ...interface{}) error {
+	var banExpiry, lockedUntil sql.NullTime
+	dest := append(extra,
+		&details.ID,
+		&details.Username,
+		&details.Email,
+		&details.Activated,
+		pq.Array(&details.Roles),
+		&banExpiry,
+		&lockedUntil,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	if banExpiry.Valid {
+		details.Banned = true
+		details.BanExpiry = &banExpiry.Time
+	}
+	if lockedUntil.Valid {
+		details.LockedUntil = &lockedUntil.Time
+	}
+	return nil
+}
+
+func (u UserModel) GetDetails(id int64) (*UserDetails, error) {
+	query := `
+	SELECT ` + userDetailsColumns + `
+	FROM users
+	LEFT JOIN bans ON bans.user_id = users.id AND bans.expiry > now()
+	WHERE users.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var details UserDetails
+	err := scanUserDetails(u.DB.QueryRowContext(ctx, query, id), &details)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &details, nil
+}
+
+// List returns a page of users whose username or email contains search.
+func (u UserModel) List(search string, filters Filters) ([]*UserDetails, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), ` + userDetailsColumns + `
+	FROM users
+	LEFT JOIN bans ON bans.user_id = users.id AND bans.expiry > now()
+	WHERE ($1 = '' OR users.username ILIKE $4 OR users.email ILIKE $4)
+	ORDER BY users.id ASC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset(), containsPattern(search))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*UserDetails{}
+	for rows.Next() {
+		var details UserDetails
+		err := scanUserDetails(rows, &details, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &details)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+func (u UserModel) SetActivated(userID int64, activated bool, actor Actor) error {
+	query := `
+	UPDATE users
+	SET activated = $1
+	FROM (SELECT id, activated FROM users WHERE id = $2 FOR UPDATE) previous
+	WHERE users.id = previous.id
+	RETURNING previous.activated`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous bool
+	err = tx.QueryRowContext(ctx, query, activated, userID).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if previous != activated {
+		err = writeAudit(ctx, tx, actor, AuditUserActivation, "user", userID,
+			map[string]bool{"activated": previous}, map[string]bool{"activated": activated})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
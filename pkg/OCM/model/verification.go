@@ -7,7 +7,6 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
-	"log"
 	"time"
 )
 
@@ -19,12 +18,24 @@ type Verification struct {
 	PlainText string    `json:"token"`
 	UserID    int64     `json:"user_id"`
 	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+	Payload   string    `json:"-"`
 }
 
-func generateVerificationCode(userID int64, ttl time.Duration) (*Verification, error) {
+// Verification scopes. A code can only be redeemed for the purpose it was
+// issued for.
+const (
+	ScopeActivation    = "activation"
+	ScopePasswordReset = "password-reset"
+	ScopeUnlock        = "unlock"
+	ScopeEmailChange   = "email-change"
+)
+
+func generateVerificationCode(userID int64, ttl time.Duration, scope string) (*Verification, error) {
 	verification := &Verification{
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
 	}
 	randomBytes := make([]byte, 16)
 
@@ -39,11 +50,18 @@ func generateVerificationCode(userID int64, ttl time.Duration) (*Verification, e
 	return verification, nil
 
 }
-func (v VerificationModel) New(userId int64, ttl time.Duration) (*Verification, error) {
-	newVer, err := generateVerificationCode(userId, ttl)
+func (v VerificationModel) New(userId int64, ttl time.Duration, scope string) (*Verification, error) {
+	return v.NewWithPayload(userId, ttl, scope, "")
+}
+
+// NewWithPayload issues a code that carries a value to apply once the code
+// is redeemed, such as the new address of an email change.
+func (v VerificationModel) NewWithPayload(userId int64, ttl time.Duration, scope, payload string) (*Verification, error) {
+	newVer, err := generateVerificationCode(userId, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
+	newVer.Payload = payload
 
 	err = v.Insert(newVer)
 	if err != nil {
@@ -54,26 +72,24 @@ func (v VerificationModel) New(userId int64, ttl time.Duration) (*Verification,
 }
 func (v VerificationModel) Insert(ver *Verification) error {
 	query := `
-	INSERT INTO verifications (code, user_id, expiry)
-	VALUES ($1, $2, $3)`
-	args := []interface{}{ver.Code, ver.UserID, ver.Expiry}
+	INSERT INTO verifications (code, user_id, expiry, scope, payload)
+	VALUES ($1, $2, $3, $4, $5)`
+	args := []interface{}{ver.Code, ver.UserID, ver.Expiry, ver.Scope, ver.Payload}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	log.Printf("Debug: Inserting verification code for user %d", ver.UserID)
-	log.Printf("Debug: Verification code: %x", ver.Code)
 	_, err := v.DB.ExecContext(ctx, query, args...)
 	return err
 
 }
-func (v VerificationModel) Delete(userID int64) error {
+func (v VerificationModel) Delete(userID int64, scope string) error {
 	query := `
 	DELETE FROM verifications
-	WHERE user_id=$1`
+	WHERE user_id=$1 AND scope=$2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := v.DB.ExecContext(ctx, query, userID)
+	_, err := v.DB.ExecContext(ctx, query, userID, scope)
 
 	return err
 }
@@ -82,19 +98,19 @@ func ValidateVerificationCode(v *validator.Validator, plainTextCode string) {
 	v.Check(plainTextCode != "", "code", "must be provided")
 }
 
-func (v VerificationModel) GetByUserID(userID int64) (*Verification, error) {
+func (v VerificationModel) GetByUserID(userID int64, scope string) (*Verification, error) {
 	query := `
-    SELECT code, user_id, expiry
+    SELECT code, user_id, expiry, scope, payload
     FROM verifications
-    WHERE user_id = $1`
+    WHERE user_id = $1 AND scope = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := v.DB.QueryRowContext(ctx, query, userID)
+	row := v.DB.QueryRowContext(ctx, query, userID, scope)
 
 	ver := &Verification{}
-	err := row.Scan(&ver.Code, &ver.UserID, &ver.Expiry)
+	err := row.Scan(&ver.Code, &ver.UserID, &ver.Expiry, &ver.Scope, &ver.Payload)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
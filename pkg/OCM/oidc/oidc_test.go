@@ -0,0 +1,324 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "ocm"
+	testRedirectURL = "http://localhost/oidc/callback"
+	testKeyID       = "test-key"
+)
+
+// mockProvider is an identity provider that serves discovery, an
+// authorization endpoint that logs the user straight in, a token endpoint
+// that checks PKCE, and the JWKS of its signing key.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// claims is changed by tests to issue bad ID tokens.
+	claims func(code authorization) jwt.MapClaims
+	// discoveryIssuer overrides the issuer in the discovery document.
+	discoveryIssuer string
+	keyID           string
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{key: key, keyID: testKeyID, codes: map[string]authorization{}}
+	m.claims = func(code authorization) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            m.URL,
+			"sub":            "user-1",
+			"aud":            testClientID,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          code.nonce,
+			"email":          "alice@example.com",
+			"email_verified": "true",
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := m.URL
+	if m.discoveryIssuer != "" {
+		issuer = m.discoveryIssuer
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": m.URL + "/authorize",
+		"token_endpoint":         m.URL + "/token",
+		"jwks_uri":               m.URL + "/jwks",
+	})
+}
+
+func (m *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	m.mu.Unlock()
+
+	redirect := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(description string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": description})
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("bad request")
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok {
+		fail("unknown code")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		fail("PKCE verification failed")
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims(code))
+	token.Header["kid"] = m.keyID
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// login runs the flow up to the callback and returns the authorization
+// code the provider redirected back with.
+func login(t *testing.T, p *Provider, state, nonce, verifier string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	return location.Query().Get("code")
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup breaks the provider or the token it issues.
+		setup func(m *mockProvider)
+		// exchangeVerifier and exchangeNonce, if set, replace what the
+		// login was started with.
+		exchangeVerifier string
+		exchangeNonce    string
+		wantErr          error
+		wantAnyErr       bool
+	}{
+		{
+			name: "valid login",
+		},
+		{
+			name: "ID token from another issuer",
+			setup: func(m *mockProvider) {
+				claims := m.claims
+				m.claims = func(code authorization) jwt.MapClaims {
+					c := claims(code)
+					c["iss"] = "https://evil.example.com"
+					return c
+				}
+			},
+			wantErr: jwt.ErrTokenInvalidIssuer,
+		},
+		{
+			name: "discovery for another issuer",
+			setup: func(m *mockProvider) {
+				m.discoveryIssuer = "https://evil.example.com"
+			},
+			wantAnyErr: true,
+		},
+		{
+			name: "ID token for another client",
+			setup: func(m *mockProvider) {
+				claims := m.claims
+				m.claims = func(code authorization) jwt.MapClaims {
+					c := claims(code)
+					c["aud"] = "someone-else"
+					return c
+				}
+			},
+			wantErr: jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name:          "nonce from another login",
+			exchangeNonce: "other-nonce",
+			wantErr:       ErrNonceMismatch,
+		},
+		{
+			name:             "wrong PKCE verifier",
+			exchangeVerifier: "not-the-verifier",
+			wantAnyErr:       true,
+		},
+		{
+			name: "expired ID token",
+			setup: func(m *mockProvider) {
+				claims := m.claims
+				m.claims = func(code authorization) jwt.MapClaims {
+					c := claims(code)
+					c["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+					c["exp"] = time.Now().Add(-time.Hour).Unix()
+					return c
+				}
+			},
+			wantErr: jwt.ErrTokenExpired,
+		},
+		{
+			name: "ID token signed with an unknown key",
+			setup: func(m *mockProvider) {
+				m.keyID = "rotated-away"
+			},
+			wantErr: ErrUnknownKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+
+			p := New(Config{Issuer: m.URL, ClientID: testClientID, RedirectURL: testRedirectURL})
+
+			const nonce = "login-nonce"
+			verifier, err := GenerateRandom()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.discoveryIssuer != "" {
+				_, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+				if err == nil {
+					t.Fatal("AuthCodeURL accepted a discovery document for another issuer")
+				}
+				return
+			}
+
+			code := login(t, p, "state", nonce, verifier)
+
+			exchangeVerifier, exchangeNonce := verifier, nonce
+			if tt.exchangeVerifier != "" {
+				exchangeVerifier = tt.exchangeVerifier
+			}
+			if tt.exchangeNonce != "" {
+				exchangeNonce = tt.exchangeNonce
+			}
+
+			idToken, err := p.Exchange(context.Background(), code, exchangeVerifier, exchangeNonce)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Exchange error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAnyErr:
+				if err == nil {
+					t.Fatal("Exchange succeeded, want an error")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Exchange: %v", err)
+				}
+				if idToken.Subject != "user-1" || idToken.Email != "alice@example.com" || !bool(idToken.EmailVerified) {
+					t.Fatalf("unexpected claims %+v", idToken)
+				}
+			}
+		})
+	}
+}
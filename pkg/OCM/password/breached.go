@@ -0,0 +1,138 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The breach index keeps the first 8 bytes of the SHA-1 of each breached
+// password, which is plenty to tell hundreds of millions of them apart.
+// The first 2 bytes select one of 65536 buckets through an offset table,
+// so only the other 6 are stored per password:
+//
+//	magic      8 bytes   "OCMBPW01"
+//	count      uint32    number of hashes, big endian
+//	offsets    65537 × uint32, start of each bucket in hashes; the last
+//	           one equals count
+//	hashes     count × 6 bytes, sorted
+const (
+	indexMagic   = "OCMBPW01"
+	bucketBytes  = 2
+	recordBytes  = 6
+	bucketCount  = 1 << (8 * bucketBytes)
+	indexedBytes = bucketBytes + recordBytes
+)
+
+var ErrInvalidIndex = errors.New("password: invalid breached password index")
+
+// Breached is a list of breached passwords loaded from an index file.
+type Breached struct {
+	offsets []uint32
+	records []byte
+}
+
+// LoadBreached reads an index file written by WriteIndex into memory.
+func LoadBreached(path string) (*Breached, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := len(indexMagic) + 4 + 4*(bucketCount+1)
+	if len(data) < headerSize || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, ErrInvalidIndex
+	}
+	count := binary.BigEndian.Uint32(data[len(indexMagic):])
+	if uint64(len(data)-headerSize) != uint64(count)*recordBytes {
+		return nil, ErrInvalidIndex
+	}
+
+	offsets := make([]uint32, bucketCount+1)
+	table := data[len(indexMagic)+4 : headerSize]
+	for i := range offsets {
+		offsets[i] = binary.BigEndian.Uint32(table[4*i:])
+		if offsets[i] > count || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, ErrInvalidIndex
+		}
+	}
+	if offsets[0] != 0 || offsets[bucketCount] != count {
+		return nil, ErrInvalidIndex
+	}
+
+	return &Breached{offsets: offsets, records: data[headerSize:]}, nil
+}
+
+// Len returns the number of passwords in the list.
+func (b *Breached) Len() int {
+	return len(b.records) / recordBytes
+}
+
+// Contains reports whether the password is in the list.
+func (b *Breached) Contains(plaintext string) bool {
+	return b.containsHash(sha1.Sum([]byte(plaintext)))
+}
+
+// containsHash reports whether a password with this SHA-1 is in the list.
+// Only the indexed prefix of the hash is compared.
+func (b *Breached) containsHash(sum [sha1.Size]byte) bool {
+	bucket := binary.BigEndian.Uint16(sum[:bucketBytes])
+	want := sum[bucketBytes:indexedBytes]
+
+	lo, hi := int(b.offsets[bucket]), int(b.offsets[int(bucket)+1])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(b.record(lo+i), want) >= 0
+	})
+	return i < hi && bytes.Equal(b.record(i), want)
+}
+
+func (b *Breached) record(i int) []byte {
+	return b.records[i*recordBytes : (i+1)*recordBytes]
+}
+
+// WriteIndex writes an index of the given SHA-1 hashes in the format
+// LoadBreached reads. hashes is sorted in place; duplicates are dropped.
+func WriteIndex(w io.Writer, hashes [][sha1.Size]byte) error {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:indexedBytes], hashes[j][:indexedBytes]) < 0
+	})
+
+	var records []byte
+	offsets := make([]uint32, bucketCount+1)
+	for i := range hashes {
+		hash := hashes[i][:]
+		if i > 0 && bytes.Equal(hashes[i-1][:indexedBytes], hash[:indexedBytes]) {
+			continue
+		}
+
+		bucket := binary.BigEndian.Uint16(hash[:bucketBytes])
+		offsets[int(bucket)+1]++
+		records = append(records, hash[bucketBytes:indexedBytes]...)
+	}
+	count := len(records) / recordBytes
+	if uint64(count) > uint64(^uint32(0)) {
+		return fmt.Errorf("password: %d hashes are too many for one index", count)
+	}
+	for i := 1; i <= bucketCount; i++ {
+		offsets[i] += offsets[i-1]
+	}
+
+	header := make([]byte, len(indexMagic)+4+4*(bucketCount+1))
+	copy(header, indexMagic)
+	binary.BigEndian.PutUint32(header[len(indexMagic):], uint32(count))
+	for i, offset := range offsets {
+		binary.BigEndian.PutUint32(header[len(indexMagic)+4+4*i:], offset)
+	}
+
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(records)
+	return err
+}
@@ -0,0 +1,148 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestIndex writes an index of the hashes to a temporary file and
+// loads it back.
+func writeTestIndex(t *testing.T, hashes [][sha1.Size]byte) *Breached {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, hashes); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "breached.idx")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LoadBreached(path)
+	if err != nil {
+		t.Fatalf("LoadBreached: %v", err)
+	}
+	return b
+}
+
+// hashWith returns a hash that is all fill except for the given prefix.
+func hashWith(fill byte, prefix ...byte) [sha1.Size]byte {
+	var h [sha1.Size]byte
+	for i := range h {
+		h[i] = fill
+	}
+	copy(h[:], prefix)
+	return h
+}
+
+func TestBreachedRoundTrip(t *testing.T) {
+	passwords := []string{"password", "123456", "qwerty", "letmein", "correct horse battery staple"}
+
+	var hashes [][sha1.Size]byte
+	for _, p := range passwords {
+		hashes = append(hashes, sha1.Sum([]byte(p)))
+	}
+	// Duplicates are dropped.
+	hashes = append(hashes, sha1.Sum([]byte("password")))
+
+	b := writeTestIndex(t, hashes)
+
+	if b.Len() != len(passwords) {
+		t.Errorf("Len() = %d, want %d", b.Len(), len(passwords))
+	}
+	for _, p := range passwords {
+		if !b.Contains(p) {
+			t.Errorf("Contains(%q) = false, want true", p)
+		}
+	}
+	for _, p := range []string{"", "Password", "password ", "tr0ub4dor&3"} {
+		if b.Contains(p) {
+			t.Errorf("Contains(%q) = true, want false", p)
+		}
+	}
+}
+
+func TestBreachedBuckets(t *testing.T) {
+	first := hashWith(0x00)
+	last := hashWith(0xff)
+	// Two records in one bucket, and one in the bucket right after it.
+	low := hashWith(0x10, 0x12, 0x34, 0x00)
+	high := hashWith(0x10, 0x12, 0x34, 0xff)
+	next := hashWith(0x00, 0x12, 0x35)
+
+	b := writeTestIndex(t, [][sha1.Size]byte{last, high, first, next, low})
+
+	tests := []struct {
+		name string
+		hash [sha1.Size]byte
+		want bool
+	}{
+		{"first bucket", first, true},
+		{"last bucket", last, true},
+		{"lower record of a bucket", low, true},
+		{"upper record of a bucket", high, true},
+		{"bucket after a full one", next, true},
+		{"between two records", hashWith(0x10, 0x12, 0x34, 0x80), false},
+		{"last indexed byte differs", hashWith(0x10, 0x12, 0x34, 0x00, 0x10, 0x10, 0x10, 0x10, 0x11), false},
+		{"only unindexed bytes differ", hashWith(0x10, 0x12, 0x34, 0x00, 0x10, 0x10, 0x10, 0x10, 0x10, 0x99), true},
+		{"empty bucket before the last", hashWith(0xff, 0xff, 0xfe), false},
+		{"other record in the first bucket", hashWith(0x00, 0x00, 0x00, 0x01), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.containsHash(tt.hash); got != tt.want {
+				t.Errorf("containsHash(%x) = %v, want %v", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedEmpty(t *testing.T) {
+	b := writeTestIndex(t, nil)
+	if b.Len() != 0 || b.Contains("password") || b.containsHash(hashWith(0xff)) {
+		t.Error("an empty index contains something")
+	}
+}
+
+func TestLoadBreachedInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, [][sha1.Size]byte{sha1.Sum([]byte("password"))}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	badMagic := append([]byte("NOTMAGIC"), valid[len(indexMagic):]...)
+	badOffset := append([]byte(nil), valid...)
+	// The offset of the first bucket must be 0.
+	badOffset[len(indexMagic)+4+3] = 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty file", nil},
+		{"bad magic", badMagic},
+		{"truncated records", valid[:len(valid)-1]},
+		{"extra bytes", append(append([]byte(nil), valid...), 0)},
+		{"bad offset table", badOffset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "breached.idx")
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadBreached(path)
+			if !errors.Is(err, ErrInvalidIndex) {
+				t.Errorf("LoadBreached error = %v, want ErrInvalidIndex", err)
+			}
+		})
+	}
+}
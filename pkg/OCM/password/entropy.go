@@ -0,0 +1,63 @@
+package password
+
+import (
+	"math"
+	"unicode"
+)
+
+// Entropy estimates the strength of a password in bits. Every character
+// is worth log2 of the size of the alphabets the password draws from, but
+// characters that repeat or continue a sequence of the previous ones
+// ("aaaa", "1234", "cba") only count a quarter, since that is roughly how
+// much less an attacker has to guess for them.
+func Entropy(plaintext string) float64 {
+	runes := []rune(plaintext)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	bitsPerChar := math.Log2(float64(pool))
+
+	bits := bitsPerChar
+	for i := 1; i < len(runes); i++ {
+		step := runes[i] - runes[i-1]
+		if step >= -1 && step <= 1 {
+			bits += bitsPerChar / 4
+		} else {
+			bits += bitsPerChar
+		}
+	}
+	return bits
+}
@@ -0,0 +1,43 @@
+package password
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	lower := math.Log2(26)
+
+	tests := []struct {
+		name      string
+		plaintext string
+		want      float64
+	}{
+		{"empty", "", 0},
+		{"one letter", "a", lower},
+		{"repeated letters", "aaaa", lower * 1.75},
+		{"ascending run", "abcd", lower * 1.75},
+		{"descending run", "dcba", lower * 1.75},
+		{"unrelated letters", "axmq", lower * 4},
+		{"upper and lower", "Aa", math.Log2(52) * 2},
+		{"digits only", "1357", math.Log2(10) * 4},
+		{"space counts as a symbol", "a b", math.Log2(59) * 3},
+		{"letters, digits and symbols", "ab1!", math.Log2(69) * 3.25},
+		{"non-ASCII", "é", math.Log2(100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Entropy(tt.plaintext)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Entropy(%q) = %v, want %v", tt.plaintext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntropyGrowsWithLength(t *testing.T) {
+	if Entropy("xq7!Lm") >= Entropy("xq7!Lm9#") {
+		t.Error("a longer password of the same kind scored no higher")
+	}
+}
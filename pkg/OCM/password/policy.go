@@ -0,0 +1,54 @@
+// Package password decides whether a new password is good enough: strong
+// enough by an entropy estimate, not built from the user's own username or
+// email address, and not known from a data breach.
+package password
+
+import (
+	"OCM/pkg/OCM/validator"
+	"strings"
+)
+
+// DefaultMinEntropy is the entropy, in bits, a password needs if nothing
+// else is configured. Eight random lower case letters score about 37.
+const DefaultMinEntropy = 40
+
+// Policy is the set of rules new passwords are checked against. Breached
+// may be nil, in which case no breach list is consulted.
+type Policy struct {
+	MinEntropy float64
+	Breached   *Breached
+}
+
+// Check adds an error for key to v if the password breaks the policy.
+// personal holds values the password must not contain, such as the
+// username and email address; empty values are ignored.
+func (p Policy) Check(v *validator.Validator, key, plaintext string, personal ...string) {
+	lower := strings.ToLower(plaintext)
+	for _, value := range personal {
+		for _, part := range personalParts(value) {
+			v.Check(!strings.Contains(lower, part), key, "must not contain your username or email address")
+		}
+	}
+
+	v.Check(Entropy(plaintext) >= p.MinEntropy, key, "is too easy to guess; use a longer password or mix in other kinds of characters")
+
+	if p.Breached != nil {
+		v.Check(!p.Breached.Contains(plaintext), key, "has appeared in a data breach and must not be used")
+	}
+}
+
+// personalParts returns the lower-cased pieces of value worth looking for
+// in a password: the value itself and, for an email address, its local
+// part. Pieces shorter than three characters would match too much.
+func personalParts(value string) []string {
+	value = strings.ToLower(value)
+
+	var parts []string
+	if len(value) >= 3 {
+		parts = append(parts, value)
+	}
+	if local, _, found := strings.Cut(value, "@"); found && len(local) >= 3 {
+		parts = append(parts, local)
+	}
+	return parts
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"OCM/pkg/OCM/validator"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteIndex(&buf, [][sha1.Size]byte{sha1.Sum([]byte("Tr0ub4dor&3-horse-staple"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "breached.idx")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreached(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := Policy{MinEntropy: DefaultMinEntropy, Breached: breached}
+	personal := []string{"alice", "bob.smith@example.com"}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		plaintext string
+		valid     bool
+	}{
+		{"strong password", policy, "gR7#vq2!Lm9x", true},
+		{"too little entropy", policy, "abcdefgh", false},
+		{"long but repetitive", policy, "aaaaaaaaaaaaaaaaaaaa", false},
+		{"contains the username", policy, "xQ9#alice#Zk2!", false},
+		{"contains the username in another case", policy, "xQ9#ALICE#Zk2!", false},
+		{"contains the email address", policy, "Bob.Smith@Example.com!7", false},
+		{"contains the local part of the email", policy, "zz!BOB.SMITH!9q", false},
+		{"breached", policy, "Tr0ub4dor&3-horse-staple", false},
+		{"breached, without a list", Policy{MinEntropy: DefaultMinEntropy}, "Tr0ub4dor&3-horse-staple", true},
+		{"no minimum", Policy{}, "abc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			tt.policy.Check(v, "password", tt.plaintext, personal...)
+			if v.Valid() != tt.valid {
+				t.Errorf("Check(%q) valid = %v, want %v (errors %v)", tt.plaintext, v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckIgnoresShortPersonalValues(t *testing.T) {
+	v := validator.New()
+	Policy{MinEntropy: DefaultMinEntropy}.Check(v, "password", "gR7#al2!Lm9x", "al", "", "al@x.io")
+	if !v.Valid() {
+		t.Errorf("short personal values were matched: %v", v.Errors)
+	}
+}